@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"os"
+)
+
+const configUsage = `Usage: go-plan-it [flags] config <command>
+
+Commands:
+  check       validate the config file, env variables and flags`
+
+func runConfig(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return fmt.Errorf("config command is not set")
+	}
+
+	switch args[0] {
+	case "check":
+		for _, warning := range cfg.Warnings() {
+			fmt.Printf("warning: %s\n", warning)
+		}
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("config is invalid:\n%w", err)
+		}
+		fmt.Println("config is valid")
+	default:
+		fmt.Fprintln(os.Stderr, configUsage)
+		return fmt.Errorf("unknown config command: %s", args[0])
+	}
+
+	return nil
+}
@@ -1,92 +1,244 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-co-op/gocron"
 	goplanit "github.com/ibovyrin/go-plan-it/internal/go-plan-it"
 	"github.com/ibovyrin/go-plan-it/pkg/calendar"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
 	"github.com/ibovyrin/go-plan-it/pkg/gpt"
+	"github.com/ibovyrin/go-plan-it/pkg/keyring"
+	"github.com/ibovyrin/go-plan-it/pkg/logging"
+	"github.com/ibovyrin/go-plan-it/pkg/tasks"
 	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	"github.com/ibovyrin/go-plan-it/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
-const (
-	oauth2ConfigFile = "credentials.json"
-	notifications    = "*/5 * * * * *"
-	morningUpdate    = "00 45 8 * * *"
-	updatesTimeout   = 60
-)
+const recentErrors = 50
 
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfig(cfg, args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	redactor := logging.NewRedactor(cfg.Secrets()...)
+	var replaceAttr func([]string, slog.Attr) slog.Attr
+	if cfg.Log.Redact {
+		replaceAttr = redactor.ReplaceAttr
+	}
+	recent := goplanit.NewRecentErrors(recentErrors, replaceAttr)
+	logger := slog.New(recent.Handler(logging.NewHandler(os.Stdout, cfg.Log, redactor)))
 	slog.SetDefault(logger)
 
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			logger.Error(fmt.Sprintf("Unknown command: %s", args[0]))
+			os.Exit(1)
+		}
+		if err := runMigrate(cfg.Database, args[1:]); err != nil {
+			logger.Error(fmt.Sprintf("Failed to migrate: %s", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		logger.Error(fmt.Sprintf("Invalid config: %s", strings.ReplaceAll(err.Error(), "\n", "; ")))
+		os.Exit(1)
+	}
+	for _, warning := range cfg.Warnings() {
+		logger.Warn(warning)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
+		os.Exit(1)
+	}
+
 	s := gocron.NewScheduler(time.Local)
 
-	bot, err := tgbot.NewBot(updatesTimeout, s, logger)
+	bot, err := tgbot.NewBot(cfg.Telegram, s, logger)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
 		os.Exit(1)
 	}
 
-	g, err := gpt.NewGPT()
+	g, err := gpt.NewGPT(cfg.OpenAI)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
 		os.Exit(1)
 	}
 
-	c, err := calendar.NewCalendar(oauth2ConfigFile)
+	c, err := calendar.NewCalendar(cfg.Google, tasks.Scope)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
 		os.Exit(1)
 	}
 
-	db, err := goplanit.NewDB()
+	k, err := keyring.NewKeyring(cfg.Database)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
 		os.Exit(1)
 	}
 
-	chats := goplanit.NewChats(db)
+	db, err := goplanit.NewDB(cfg.Database, k)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
+		os.Exit(1)
+	}
 
-	app, err := goplanit.NewApp(chats, g, c, logger, bot)
+	storage := goplanit.NewStorage(db)
+	if err := bot.SetOutboxStore(goplanit.NewOutbox(db)); err != nil {
+		logger.Error(fmt.Sprintf("Failed to load outbound messages: %s", err))
+		os.Exit(1)
+	}
+
+	app, err := goplanit.NewApp(cfg.Access, storage, g, c, tasks.NewTasks(), logger, bot)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
 		os.Exit(1)
 	}
 
-	err = bot.RegisterScheduledHandler(notifications, app.SendNotifications)
+	err = bot.RegisterScheduledHandler(cfg.Schedule.Notifications, app.SendNotifications)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
 		os.Exit(1)
 	}
-	err = bot.RegisterScheduledHandler(morningUpdate, app.SendMorningAgenda)
+	err = bot.RegisterScheduledHandler(cfg.Schedule.MorningUpdate, app.SendMorningAgenda)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
 		os.Exit(1)
 	}
 
+	app.SetRecentErrors(recent)
+	bot.Use(app.IsAllowed)
+
+	bot.RegisterCommand("invite", []func(*tgbot.Context){app.IsAdmin, app.HandleInviteCommand})
+	bot.RegisterCommand("users", []func(*tgbot.Context){app.IsAdmin, app.HandleUsersCommand})
+	bot.RegisterCommand("role", []func(*tgbot.Context){app.IsAdmin, app.HandleRoleCommand})
+	bot.RegisterCommand("admin", []func(*tgbot.Context){app.IsAdmin, app.HandleAdminCommand})
+
 	bot.RegisterCommand("new", []func(*tgbot.Context){app.IsSubscribed, app.HandleNewEventCommand})
 	bot.RegisterCommand("new", []func(*tgbot.Context){app.IsSubscribed, app.HandleNewEventCommandResponse}, true)
 
+	bot.RegisterCommand("todo", []func(*tgbot.Context){app.IsRegistered, app.HandleTodoCommand})
+	bot.RegisterCommand("todo", []func(*tgbot.Context){app.IsRegistered, app.HandleTodoCommandResponse}, true)
+	bot.RegisterCommand("tasks", []func(*tgbot.Context){app.IsRegistered, app.HandleTasksCommand})
+	bot.RegisterCallbackHandler("task", []func(*tgbot.Context){app.IsRegistered, app.HandleTaskCallback})
+
+	bot.RegisterCommand("slot", []func(*tgbot.Context){app.IsSubscribed, app.HandleSlotCommand})
+	bot.RegisterCommand("slot", []func(*tgbot.Context){app.IsSubscribed, app.HandleSlotCommandResponse}, true)
+	bot.RegisterCallbackHandler("slot", []func(*tgbot.Context){app.IsSubscribed, app.HandleSlotCallback})
+	bot.RegisterCommand("contacts", []func(*tgbot.Context){app.IsGroupAdmin, app.IsRegistered, app.HandleContactsCommand})
+	bot.RegisterCommand("workhours", []func(*tgbot.Context){app.IsGroupAdmin, app.IsRegistered, app.HandleWorkHoursCommand})
+
 	bot.RegisterCommand("events", []func(*tgbot.Context){app.IsSubscribed, app.HandleEventsCommand})
-	bot.RegisterCommand("watch", []func(*tgbot.Context){app.IsRegistered, app.HandleWatchCommand})
-	bot.RegisterCommand("stopwatch", []func(*tgbot.Context){app.IsSubscribed, app.HandleStopWatchCommand})
-	bot.RegisterCommand("start", []func(*tgbot.Context){app.HandleStartCommand})
-	bot.RegisterCommand("stop", []func(*tgbot.Context){app.IsExists, app.HandleStopCommand})
+	bot.RegisterCallbackHandler("events", []func(*tgbot.Context){app.IsSubscribed, app.HandleEventsCallback})
+	bot.RegisterCommand("watch", []func(*tgbot.Context){app.IsGroupAdmin, app.IsRegistered, app.HandleWatchCommand})
+	bot.RegisterCommand("stopwatch", []func(*tgbot.Context){app.IsGroupAdmin, app.IsSubscribed, app.HandleStopWatchCommand})
+	bot.RegisterCommand("donestyle", []func(*tgbot.Context){app.IsGroupAdmin, app.IsRegistered, app.HandleDoneStyleCommand})
+	bot.RegisterCallbackHandler("snooze", []func(*tgbot.Context){app.IsSubscribed, app.HandleSnoozeCallback})
+	bot.RegisterCallbackHandler("done", []func(*tgbot.Context){app.IsSubscribed, app.HandleDoneCallback})
+	bot.RegisterCallbackHandler("rsvp", []func(*tgbot.Context){app.IsGroupAdmin, app.IsSubscribed, app.HandleRSVPCallback})
+	bot.RegisterCommand("start", []func(*tgbot.Context){app.IsGroupAdmin, app.HandleStartCommand})
+	bot.RegisterCommand("stop", []func(*tgbot.Context){app.IsGroupAdmin, app.IsExists, app.HandleStopCommand})
+	bot.RegisterCommand("stop", []func(*tgbot.Context){app.IsGroupAdmin, app.IsExists, app.HandleStopCommandResponse}, true)
 
-	router := gin.Default()
+	if cfg.Log.SlogLevel() > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router := gin.New()
+	router.Use(gin.Recovery(), goplanit.RequestLogger(logger), goplanit.TraceRequests)
 	router.GET("/login", app.HandleLoginWebhook)
 	router.POST("/webhook/:chatId", app.HandleCalendarWebhook)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	health := goplanit.NewHealth(db, bot, g, logger)
+	router.GET("/healthz", health.HandleHealthz)
+	router.GET("/readyz", health.HandleReadyz)
+	if cfg.Server.AdminPassword != "" {
+		router.GET("/admin", gin.BasicAuth(gin.Accounts{"admin": cfg.Server.AdminPassword}), app.HandleAdminPage)
+	}
+	if bot.WebhookEnabled() {
+		router.POST(bot.WebhookPath(), gin.WrapF(bot.HandleWebhook))
+	}
 
-	// TODO graceful shutdown
-	go bot.RunUpdatesHandler()
-	err = router.Run("0.0.0.0:80")
-	if err != nil {
+	server := &http.Server{
+		Addr:    cfg.Server.Address,
+		Handler: router,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	serverErr := make(chan error, 1)
+	botErr := make(chan error, 1)
+	go func() {
+		botErr <- bot.RunUpdatesHandler()
+	}()
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutting down")
+	case err := <-serverErr:
 		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
-		os.Exit(1)
+		exitCode = 1
+	case err := <-botErr:
+		logger.Error(fmt.Sprintf("Failed to start app: %s", err))
+		exitCode = 1
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+
+	if err := bot.Shutdown(shutdownCtx); err != nil {
+		logger.Error(fmt.Sprintf("Failed to stop bot: %s", err))
+		exitCode = 1
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error(fmt.Sprintf("Failed to stop http server: %s", err))
+		exitCode = 1
+	}
+
+	if err := goplanit.CloseDB(db); err != nil {
+		logger.Error(fmt.Sprintf("Failed to close db: %s", err))
+		exitCode = 1
 	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error(fmt.Sprintf("Failed to flush traces: %s", err))
+		exitCode = 1
+	}
+
+	cancel()
+	os.Exit(exitCode)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	goplanit "github.com/ibovyrin/go-plan-it/internal/go-plan-it"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"os"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = `Usage: go-plan-it [flags] migrate <command>
+
+Commands:
+  up          apply all pending migrations
+  down [n]    roll back the last n migrations, 1 by default
+  status      show applied and pending migrations`
+
+func runMigrate(config config.Database, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return fmt.Errorf("migrate command is not set")
+	}
+
+	db, err := goplanit.OpenDB(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	m := goplanit.NewMigrator(db)
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, migration := range applied {
+			fmt.Printf("applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations: %s", args[1])
+			}
+		}
+		rolledBack, err := m.Down(steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = fmt.Sprintf("applied at %s", s.AppliedAt.Format(time.RFC3339))
+			}
+			fmt.Printf("%4d  %-40s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,338 @@
+package go_plan_it
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// channelRenewBefore is how long before the expiration a watch channel is renewed
+const channelRenewBefore = time.Hour
+
+// SetRecentErrors sets the errors shown on the admin page, it must be called before handling updates.
+func (a *App) SetRecentErrors(recent *RecentErrors) {
+	a.recentErrors = recent
+}
+
+// channelExpiresAt returns the expiration of the chat watch channel, google reports it in milliseconds.
+func channelExpiresAt(chat *Chat) (time.Time, bool) {
+	if chat.ChannelExpiration == nil || *chat.ChannelExpiration == 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(*chat.ChannelExpiration), true
+}
+
+// renewWatchChannel replaces the watch channel of the chat with a new one.
+func (a *App) renewWatchChannel(ctx context.Context, chat *Chat) error {
+	webhookPath := strconv.FormatInt(chat.ChatId, 10)
+	channel, err := a.calendar.CreateWatchChannel(ctx, *chat.CalendarId, webhookPath, a.tokenSource(chat))
+	if err != nil {
+		return fmt.Errorf("renewWatchChannel: %w", err)
+	}
+
+	if chat.ChannelId != nil && chat.ChannelResourceId != nil {
+		err = a.calendar.DeleteWatchChannel(ctx, *chat.ChannelId, *chat.ChannelResourceId, a.tokenSource(chat))
+		if err != nil {
+			// the old channel expires by itself
+			a.logger.Warn(fmt.Sprintf("Failed to delete old watch channel of chat %d: %s", chat.ChatId, err))
+		}
+	}
+
+	chat.ChannelId = &channel.Id
+	chat.ChannelResourceId = &channel.ResourceId
+	chat.ChannelExpiration = &channel.Expiration
+
+	if err := a.chats.UpdateChannel(chat.ChatId, chat.ChannelId, chat.ChannelResourceId, chat.ChannelExpiration); err != nil {
+		return fmt.Errorf("renewWatchChannel: failed to update chat: %w", err)
+	}
+	return nil
+}
+
+func chatStatus(chat *Chat, now time.Time) string {
+	switch {
+	case !chat.Registered:
+		return "not registered"
+	case chat.NeedsReauth:
+		return "needs login"
+	case chat.CalendarId == nil:
+		return "not subscribed"
+	}
+
+	expiresAt, ok := channelExpiresAt(chat)
+	switch {
+	case !ok:
+		return "no channel"
+	case expiresAt.Before(now):
+		return "channel expired"
+	}
+	return "ok"
+}
+
+type adminStats struct {
+	Chats        int
+	Groups       int
+	Registered   int
+	Subscribed   int
+	Statuses     map[string]int
+	Expiring     int
+	UsersByRole  map[string]int
+	ChatsDetails []adminChat
+}
+
+type adminChat struct {
+	ChatId    int64
+	Status    string
+	ExpiresAt string
+}
+
+func (a *App) collectStats() (*adminStats, error) {
+	chats, err := a.chats.GetChats()
+	if err != nil {
+		return nil, fmt.Errorf("collectStats: %w", err)
+	}
+
+	users, err := a.users.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("collectStats: %w", err)
+	}
+
+	now := time.Now()
+	stats := &adminStats{
+		Statuses:     make(map[string]int),
+		UsersByRole:  make(map[string]int),
+		ChatsDetails: make([]adminChat, 0, len(chats)),
+	}
+
+	for _, chat := range chats {
+		stats.Chats++
+		if chat.isGroup() {
+			stats.Groups++
+		}
+		if chat.Registered {
+			stats.Registered++
+		}
+		if chat.CalendarId != nil {
+			stats.Subscribed++
+		}
+
+		status := chatStatus(chat, now)
+		stats.Statuses[status]++
+
+		details := adminChat{ChatId: chat.ChatId, Status: status}
+		if expiresAt, ok := channelExpiresAt(chat); ok {
+			details.ExpiresAt = expiresAt.Local().Format("2006-01-02 15:04")
+			if expiresAt.After(now) && expiresAt.Before(now.Add(24*time.Hour)) {
+				stats.Expiring++
+			}
+		}
+		stats.ChatsDetails = append(stats.ChatsDetails, details)
+	}
+
+	for _, user := range users {
+		stats.UsersByRole[user.Role]++
+	}
+
+	return stats, nil
+}
+
+func (a *App) HandleAdminCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/admin")
+
+	action, args, _ := strings.Cut(strings.TrimSpace(c.Update.Message.CommandArguments()), " ")
+	args = strings.TrimSpace(args)
+
+	switch action {
+	case "stats":
+		a.adminStats(c, l)
+	case "chats":
+		a.adminChats(c, l)
+	case "broadcast":
+		a.adminBroadcast(c, l, args)
+	case "resync":
+		a.adminResync(c, l, args)
+	default:
+		c.AbortWithMessage("Use /admin stats, /admin chats, /admin broadcast <text> or /admin resync <chat id>.")
+	}
+}
+
+func (a *App) adminStats(c *tgbot.Context, l *slog.Logger) {
+	stats, err := a.collectStats()
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to collect stats: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	lines := []string{
+		fmt.Sprintf("Chats: %d (%d groups)", stats.Chats, stats.Groups),
+		fmt.Sprintf("Registered: %d", stats.Registered),
+		fmt.Sprintf("Subscribed: %d", stats.Subscribed),
+		fmt.Sprintf("Channels expiring in 24h: %d", stats.Expiring),
+	}
+	for status, n := range stats.Statuses {
+		lines = append(lines, fmt.Sprintf("Status %s: %d", status, n))
+	}
+	for role, n := range stats.UsersByRole {
+		lines = append(lines, fmt.Sprintf("Users with role %s: %d", role, n))
+	}
+	if a.recentErrors != nil {
+		lines = append(lines, fmt.Sprintf("Recent errors: %d", len(a.recentErrors.Records())))
+	}
+
+	c.AddMessage(strings.Join(lines, "\n"))
+}
+
+func (a *App) adminChats(c *tgbot.Context, l *slog.Logger) {
+	stats, err := a.collectStats()
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to collect stats: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if len(stats.ChatsDetails) == 0 {
+		c.AbortWithMessage("There are no chats.")
+		return
+	}
+
+	lines := make([]string, 0, len(stats.ChatsDetails))
+	for _, chat := range stats.ChatsDetails {
+		line := fmt.Sprintf("%d - %s", chat.ChatId, chat.Status)
+		if chat.ExpiresAt != "" {
+			line += fmt.Sprintf(", channel expires %s", chat.ExpiresAt)
+		}
+		lines = append(lines, line)
+	}
+
+	for _, text := range splitLines(strings.Join(lines, "\n")) {
+		c.AddMessage(text)
+	}
+}
+
+func (a *App) adminBroadcast(c *tgbot.Context, l *slog.Logger, text string) {
+	if text == "" {
+		c.AbortWithMessage("Use /admin broadcast <text>.")
+		return
+	}
+
+	chats, err := a.chats.GetChats()
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chats: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	messages := make([]*tgbotapi.MessageConfig, 0, len(chats))
+	for _, chat := range chats {
+		if chat.Registered {
+			messages = append(messages, tgbot.CreateMessage(chat.ChatId, text))
+		}
+	}
+	a.bot.SendBatchMessages(messages)
+
+	c.AddMessage(fmt.Sprintf("The message is queued for %d chats.", len(messages)))
+}
+
+func (a *App) adminResync(c *tgbot.Context, l *slog.Logger, arg string) {
+	chatId, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		c.AbortWithMessage("Use /admin resync <chat id>.")
+		return
+	}
+
+	chat, err := a.chats.GetChatById(chatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat %d: %s", chatId, err))
+		c.AbortWithMessage(fmt.Sprintf("Chat %d is not found.", chatId))
+		return
+	}
+
+	if chat.CalendarId == nil || !chat.Registered || chat.NeedsReauth {
+		c.AbortWithMessage(fmt.Sprintf("Chat %d can't be resynced: %s.", chatId, chatStatus(chat, time.Now())))
+		return
+	}
+
+	ctx := c.Context()
+	if err := a.renewWatchChannel(ctx, chat); err != nil {
+		l.Error(fmt.Sprintf("Failed to renew watch channel of chat %d: %s", chatId, err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if err := a.setNextUpdateTimeForChat(ctx, chat); err != nil {
+		l.Error(fmt.Sprintf("Failed to set next update time of chat %d: %s", chatId, err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	c.AddMessage(fmt.Sprintf("Chat %d is resynced, the watch channel is renewed.", chatId))
+}
+
+var adminPage = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Go Plan IT admin</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.bad { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Go Plan IT</h1>
+<h2>Summary</h2>
+<table>
+<tr><th>Chats</th><td>{{.Stats.Chats}} ({{.Stats.Groups}} groups)</td></tr>
+<tr><th>Registered</th><td>{{.Stats.Registered}}</td></tr>
+<tr><th>Subscribed</th><td>{{.Stats.Subscribed}}</td></tr>
+<tr><th>Channels expiring in 24h</th><td>{{.Stats.Expiring}}</td></tr>
+{{range $role, $n := .Stats.UsersByRole}}<tr><th>Users with role {{$role}}</th><td>{{$n}}</td></tr>
+{{end}}</table>
+<h2>Subscription health</h2>
+<table>
+<tr><th>Chat</th><th>Status</th><th>Channel expires</th></tr>
+{{range .Stats.ChatsDetails}}<tr><td>{{.ChatId}}</td><td{{if ne .Status "ok"}} class="bad"{{end}}>{{.Status}}</td><td>{{.ExpiresAt}}</td></tr>
+{{end}}</table>
+<h2>Recent errors</h2>
+<table>
+<tr><th>Time</th><th>Message</th></tr>
+{{range .Errors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Message}}</td></tr>
+{{else}}<tr><td colspan="2">No errors</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// HandleAdminPage renders the operator dashboard, the route must be protected by authentication.
+func (a *App) HandleAdminPage(c *gin.Context) {
+	stats, err := a.collectStats()
+	if err != nil {
+		requestLogger(c, a.logger).Error(fmt.Sprintf("Failed to collect stats: %s", err))
+		c.String(http.StatusInternalServerError, errorMessage)
+		return
+	}
+
+	var records []ErrorRecord
+	if a.recentErrors != nil {
+		records = a.recentErrors.Records()
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := adminPage.Execute(c.Writer, struct {
+		Stats  *adminStats
+		Errors []ErrorRecord
+	}{stats, records}); err != nil {
+		requestLogger(c, a.logger).Error(fmt.Sprintf("Failed to render admin page: %s", err))
+	}
+}
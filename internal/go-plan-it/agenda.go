@@ -0,0 +1,214 @@
+package go_plan_it
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gCalendar "google.golang.org/api/calendar/v3"
+	gTasks "google.golang.org/api/tasks/v1"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout     = "2006-01-02"
+	dayLayout      = "Monday, 2 January"
+	timeLayout     = "15:04"
+	maxTitleLength = 200
+)
+
+func escapeMarkdown(s string) string {
+	for _, c := range specialChars {
+		s = strings.Replace(s, c, fmt.Sprintf("\\%s", c), -1)
+	}
+	return s
+}
+
+// eventTime returns the start and end of the event in the local time zone.
+func eventTime(event *gCalendar.Event) (time.Time, time.Time, bool, error) {
+	if event.Start == nil || event.End == nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("event %s has no start or end", event.Id)
+	}
+
+	if event.Start.DateTime == "" {
+		start, err := time.ParseInLocation(dateLayout, event.Start.Date, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, false, err
+		}
+		end, err := time.ParseInLocation(dateLayout, event.End.Date, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, false, err
+		}
+		return start, end, true, nil
+	}
+
+	start, err := time.Parse(time.RFC3339, event.Start.DateTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	end, err := time.Parse(time.RFC3339, event.End.DateTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	return start.In(time.Local), end.In(time.Local), false, nil
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	parts := make([]string, 0, 3)
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%d d", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%d h", hours))
+	}
+	if minutes > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%d min", minutes))
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatEventLine(event *gCalendar.Event, start, end time.Time, allDay bool) string {
+	summary := event.Summary
+	if summary == "" {
+		summary = "(no title)"
+	}
+	if len([]rune(summary)) > maxTitleLength {
+		summary = string([]rune(summary)[:maxTitleLength]) + "…"
+	}
+
+	var when string
+	if allDay {
+		when = "All day"
+		if days := int(end.Sub(start).Hours() / 24); days > 1 {
+			when = fmt.Sprintf("All day (%d days)", days)
+		}
+	} else {
+		when = fmt.Sprintf("%s–%s (%s)", start.Format(timeLayout), end.Format(timeLayout), formatDuration(end.Sub(start)))
+	}
+
+	line := fmt.Sprintf("• %s ", escapeMarkdown(when))
+	if event.HtmlLink != "" {
+		line += fmt.Sprintf("[%s](%s)", escapeMarkdown(summary), event.HtmlLink)
+	} else {
+		line += escapeMarkdown(summary)
+	}
+
+	if event.Location != "" {
+		line += fmt.Sprintf("\n    📍 %s", escapeMarkdown(event.Location))
+	}
+
+	return line
+}
+
+// agendaBuilder splits agenda lines into messages which fit into a telegram
+// message, repeating the current section header in the next message.
+type agendaBuilder struct {
+	messages []string
+	b        strings.Builder
+	section  string
+}
+
+func (ab *agendaBuilder) write(header, line string) {
+	if ab.b.Len()+len(header)+len(line) > tgbot.MaxMessageLength {
+		ab.messages = append(ab.messages, ab.b.String())
+		ab.b.Reset()
+		if header == "" {
+			header = fmt.Sprintf("*%s \\(continued\\)*", escapeMarkdown(ab.section))
+		} else {
+			header = strings.TrimPrefix(header, "\n\n")
+		}
+	}
+
+	ab.b.WriteString(header)
+	ab.b.WriteString(line)
+}
+
+// startSection returns the header of a new group of lines, or nothing if the group is already started.
+func (ab *agendaBuilder) startSection(name string) string {
+	if name == ab.section {
+		return ""
+	}
+	ab.section = name
+	return fmt.Sprintf("\n\n*%s*", escapeMarkdown(name))
+}
+
+func (ab *agendaBuilder) result() []string {
+	return append(ab.messages, ab.b.String())
+}
+
+// formatAgenda renders events grouped by day and tasks as MarkdownV2 messages. Messages
+// are split on line boundaries when they don't fit into one telegram message.
+func formatAgenda(title string, events []*gCalendar.Event, todos []*gTasks.Task) []string {
+	ab := &agendaBuilder{}
+	ab.b.WriteString(escapeMarkdown(title))
+
+	for _, event := range events {
+		start, end, allDay, err := eventTime(event)
+		if err != nil {
+			continue
+		}
+
+		header := ab.startSection(start.Format(dayLayout))
+		ab.write(header, "\n"+formatEventLine(event, start, end, allDay))
+	}
+
+	for _, todo := range todos {
+		header := ab.startSection("To-do")
+		ab.write(header, "\n"+formatTaskLine(todo))
+	}
+
+	return ab.result()
+}
+
+func formatTaskLine(task *gTasks.Task) string {
+	title := task.Title
+	if title == "" {
+		title = "(no title)"
+	}
+	if len([]rune(title)) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength]) + "…"
+	}
+
+	line := fmt.Sprintf("• %s", escapeMarkdown(title))
+	if due, err := time.Parse(time.RFC3339, task.Due); err == nil {
+		line += escapeMarkdown(fmt.Sprintf(" - due %s", due.UTC().Format("2 Jan")))
+	}
+	return line
+}
+
+// splitLines splits plain text on line boundaries into parts which fit into a telegram message.
+func splitLines(text string) []string {
+	parts := make([]string, 0, 1)
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		if b.Len() > 0 && b.Len()+len("\n")+len(line) > tgbot.MaxMessageLength {
+			parts = append(parts, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(line)
+	}
+	return append(parts, b.String())
+}
+
+func agendaMessages(chatId int64, texts []string, keyboard *tgbotapi.InlineKeyboardMarkup) []*tgbotapi.MessageConfig {
+	messages := make([]*tgbotapi.MessageConfig, 0, len(texts))
+	for i, text := range texts {
+		options := tgbot.MessageWithOptions{
+			ParseMode:             tgbotapi.ModeMarkdownV2,
+			DisableWebPagePreview: true,
+		}
+		if i == len(texts)-1 {
+			options.InlineKeyboard = keyboard
+		}
+		messages = append(messages, tgbot.CreateMessageWithOptions(chatId, text, options))
+	}
+	return messages
+}
@@ -0,0 +1,29 @@
+package go_plan_it
+
+import (
+	"testing"
+)
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain text", text: "Standup", want: "Standup"},
+		{name: "time range", text: "10:00 - 11:00", want: "10:00 \\- 11:00"},
+		{name: "phone number", text: "Call +1 (555) 123", want: "Call \\+1 \\(555\\) 123"},
+		{name: "link", text: "[docs](https://example.com/a_b)", want: "\\[docs\\]\\(https://example\\.com/a\\_b\\)"},
+		{name: "backslash", text: "a\\b", want: "a\\\\b"},
+		{name: "not special", text: "Q&A, 100% @home", want: "Q&A, 100% @home"},
+		{name: "all special", text: "_*[]()~`>#+-=|{}.!", want: "\\_\\*\\[\\]\\(\\)\\~\\`\\>\\#\\+\\-\\=\\|\\{\\}\\.\\!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeMarkdown(tt.text); got != tt.want {
+				t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -8,43 +8,68 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/golang-module/carbon"
 	"github.com/ibovyrin/go-plan-it/pkg/calendar"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
 	"github.com/ibovyrin/go-plan-it/pkg/gpt"
+	"github.com/ibovyrin/go-plan-it/pkg/metrics"
+	"github.com/ibovyrin/go-plan-it/pkg/tasks"
 	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
 	gCalendar "google.golang.org/api/calendar/v3"
-	"gorm.io/gorm"
 	"log/slog"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const errorMessage = "Something went wrong. Please try again later."
 
 var specialChars = []string{
 	"\\", "_", "*", "[", "]", "(", ")", "~", "`", ">",
-	"&", "#", "-", "=", "|", "{", "}", ".", "!"}
+	"#", "+", "-", "=", "|", "{", "}", ".", "!"}
 
 type App struct {
-	chats    *Chats
-	gpt      *gpt.GPT
-	calendar *calendar.Calendar
-	bot      *tgbot.Bot
-	logger   *slog.Logger
+	chats     ChatRepository
+	reminders ReminderRepository
+	contacts  ContactRepository
+	responses AttendeeResponseRepository
+	users     UserRepository
+	invites   InviteRepository
+	access    config.Access
+	gpt       *gpt.GPT
+	calendar  *calendar.Calendar
+	tasks     *tasks.Tasks
+	bot       *tgbot.Bot
+	logger    *slog.Logger
+	proposals *proposals
+
+	recentErrors *RecentErrors
 }
 
-func NewApp(chats *Chats, gpt *gpt.GPT, calendar *calendar.Calendar, logger *slog.Logger, bot *tgbot.Bot) (*App, error) {
+func NewApp(access config.Access, storage *Storage, gpt *gpt.GPT, calendar *calendar.Calendar, tasks *tasks.Tasks, logger *slog.Logger, bot *tgbot.Bot) (*App, error) {
 	app := App{
-		chats:    chats,
-		gpt:      gpt,
-		calendar: calendar,
-		bot:      bot,
-		logger:   logger.WithGroup("app"),
+		chats:     storage.Chats,
+		reminders: storage.Reminders,
+		contacts:  storage.Contacts,
+		responses: storage.Responses,
+		users:     storage.Users,
+		invites:   storage.Invites,
+		gpt:       gpt,
+		calendar:  calendar,
+		tasks:     tasks,
+		bot:       bot,
+		logger:    logger.WithGroup("app"),
+		proposals: newProposals(),
+		access:    access,
+	}
+
+	if err := app.seedAdmins(app.access.Admins); err != nil {
+		return nil, err
 	}
 
 	return &app, nil
 }
 
 func (a *App) HandleWatchCommand(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "command", "/watch")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/watch")
 
 	chat, err := a.chats.GetChatById(c.ChatId)
 	if err != nil {
@@ -53,8 +78,10 @@ func (a *App) HandleWatchCommand(c *tgbot.Context) {
 		return
 	}
 
-	if c.Update.Message.CommandArguments() == "" {
-		calendars, err := a.calendar.GetCalendarsList(context.Background(), chat.Token)
+	// calendar names may contain spaces, the id is the last argument
+	args := strings.Fields(c.Update.Message.CommandArguments())
+	if len(args) == 0 {
+		calendars, err := a.calendar.GetCalendarsList(c.Context(), a.tokenSource(chat))
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to get calendars list: %s", err))
 			c.AbortWithMessage(errorMessage)
@@ -69,12 +96,10 @@ func (a *App) HandleWatchCommand(c *tgbot.Context) {
 		return
 	}
 
-	ctx := context.Background()
-	args := strings.Split(c.Update.Message.CommandArguments(), " ")
-
-	chat.CalendarId = &args[1]
+	ctx := c.Context()
+	chat.CalendarId = &args[len(args)-1]
 	webhookPath := strconv.FormatInt(c.ChatId, 10)
-	channel, err := a.calendar.CreateWatchChannel(ctx, *chat.CalendarId, webhookPath, chat.Token)
+	channel, err := a.calendar.CreateWatchChannel(ctx, *chat.CalendarId, webhookPath, a.tokenSource(chat))
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to create watch channel: %s", err))
 		return
@@ -84,6 +109,12 @@ func (a *App) HandleWatchCommand(c *tgbot.Context) {
 	chat.ChannelResourceId = &channel.ResourceId
 	chat.ChannelExpiration = &channel.Expiration
 
+	if err := a.chats.UpdateChat(chat); err != nil {
+		l.Error(fmt.Sprintf("Failed to update chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
 	err = a.setNextUpdateTimeForChat(ctx, chat)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to set next chat update time: %s", err))
@@ -94,7 +125,7 @@ func (a *App) HandleWatchCommand(c *tgbot.Context) {
 }
 
 func (a *App) HandleStopWatchCommand(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "command", "/stopwatch")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/stopwatch")
 
 	chat, err := a.chats.GetChatById(c.ChatId)
 	if err != nil {
@@ -103,7 +134,7 @@ func (a *App) HandleStopWatchCommand(c *tgbot.Context) {
 		return
 	}
 
-	err = a.calendar.DeleteWatchChannel(context.Background(), *chat.ChannelId, *chat.ChannelResourceId, chat.Token)
+	err = a.calendar.DeleteWatchChannel(c.Context(), *chat.ChannelId, *chat.ChannelResourceId, a.tokenSource(chat))
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to delete watch channel: %s", err))
 		c.AbortWithMessage(errorMessage)
@@ -127,26 +158,33 @@ func (a *App) HandleStopWatchCommand(c *tgbot.Context) {
 }
 
 func (a *App) HandleStartCommand(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "command", "/start")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/start")
 
 	chat, err := a.chats.CreateChat(c.ChatId)
 
 	switch {
-	case errors.Is(err, gorm.ErrDuplicatedKey):
-		c.AbortWithMessage("You are already registered. Use /stop to stop using this bot.")
-		return
+	case errors.Is(err, ErrChatExists):
+		chat, err = a.chats.GetChatById(c.ChatId)
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+			c.AbortWithMessage(errorMessage)
+			return
+		}
 	case !errors.Is(err, nil):
 		l.Error(fmt.Sprintf("Failed to create chat: %s", err))
 		c.AbortWithMessage(errorMessage)
 		return
 	}
 
-	if chat.Registered {
+	if chat.Registered && !chat.NeedsReauth {
 		c.AbortWithMessage("You are already registered. Use /stop to stop using this bot.")
 		return
 	}
 
 	c.AddMessage("Hello, I'm a bot that can show you your tasks from Google Calendar.\nIf you want to use me, you need to authorize me.\n")
+	if c.IsGroup() {
+		c.AddMessage("The calendar you link is shared with everyone in this group, so the link below is meant for the admin who sent /start.")
+	}
 	state := strconv.FormatInt(c.ChatId, 10)
 	authCodeURL := a.calendar.GetAuthURL(state)
 	c.AddMessage(fmt.Sprintf("In order to authorize me, follow this link: \n%s", authCodeURL))
@@ -155,7 +193,7 @@ func (a *App) HandleStartCommand(c *tgbot.Context) {
 func (a *App) getChatById(c *tgbot.Context) (*Chat, error) {
 	chat, err := a.chats.GetChatById(c.ChatId)
 	switch {
-	case errors.Is(err, gorm.ErrRecordNotFound):
+	case errors.Is(err, ErrChatNotFound):
 		c.AbortWithMessage("You are not registered. Use /start to start using this bot.")
 		return nil, err
 	case !errors.Is(err, nil):
@@ -166,8 +204,33 @@ func (a *App) getChatById(c *tgbot.Context) (*Chat, error) {
 	return chat, nil
 }
 
+// IsGroupAdmin lets only administrators of a group change its settings, private chats pass through.
+func (a *App) IsGroupAdmin(c *tgbot.Context) {
+	if !c.IsGroup() {
+		return
+	}
+
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "user_id", c.UserId, "middleware", "IsGroupAdmin")
+
+	// anonymous administrators send messages on behalf of the group
+	if msg := c.Update.Message; msg != nil && msg.SenderChat != nil && msg.SenderChat.ID == c.ChatId {
+		return
+	}
+
+	admin, err := a.bot.IsChatAdmin(c.ChatId, c.UserId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to check chat admin: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if !admin {
+		c.AbortWithMessage("Only group admins can do this.")
+	}
+}
+
 func (a *App) IsExists(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "middleware", "IsRegistered")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "middleware", "IsRegistered")
 
 	_, err := a.getChatById(c)
 	if err != nil {
@@ -177,7 +240,7 @@ func (a *App) IsExists(c *tgbot.Context) {
 }
 
 func (a *App) IsRegistered(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "middleware", "IsRegistered")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "middleware", "IsRegistered")
 
 	chat, err := a.getChatById(c)
 	if err != nil {
@@ -189,10 +252,15 @@ func (a *App) IsRegistered(c *tgbot.Context) {
 		c.AbortWithMessage("You are not registered. Use /start to start using this bot.")
 		return
 	}
+
+	if chat.NeedsReauth {
+		c.AbortWithMessage(reauthMessage)
+		return
+	}
 }
 
 func (a *App) IsSubscribed(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "middleware", "IsSubscribed")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "middleware", "IsSubscribed")
 
 	chat, err := a.getChatById(c)
 	if err != nil {
@@ -205,6 +273,11 @@ func (a *App) IsSubscribed(c *tgbot.Context) {
 		return
 	}
 
+	if chat.NeedsReauth {
+		c.AbortWithMessage(reauthMessage)
+		return
+	}
+
 	if chat.CalendarId == nil {
 		c.AbortWithMessage("You are not subscribed to any calendar. Use /watch to subscribe.")
 		return
@@ -212,21 +285,129 @@ func (a *App) IsSubscribed(c *tgbot.Context) {
 }
 
 func (a *App) HandleStopCommand(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "command", "/stop")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/stop")
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	contacts, err := a.contacts.GetChatContacts(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get contacts: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	settings, err := exportSettings(chat, contacts)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to export settings: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	c.AddMessage(fmt.Sprintf("Here is a copy of your settings, save it if you want to come back later:\n%s", settings))
+	c.AddMessage("All your data will be deleted and Google access will be revoked. Reply \"yes\" to confirm.")
+	c.RegisterWaitForInput()
+}
+
+func (a *App) HandleStopCommandResponse(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/stop_response")
+
+	if !strings.EqualFold(strings.TrimSpace(c.Update.Message.Text), "yes") {
+		c.AbortWithMessage("Ok, I keep your data.")
+		return
+	}
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	ctx := c.Context()
+	if chat.ChannelId != nil && chat.ChannelResourceId != nil && chat.Token != nil {
+		err = a.calendar.DeleteWatchChannel(ctx, *chat.ChannelId, *chat.ChannelResourceId, a.tokenSource(chat))
+		if err != nil {
+			// the channel expires by itself, webhooks for an unknown chat are ignored
+			l.Warn(fmt.Sprintf("Failed to delete watch channel: %s", err))
+		}
+	}
+
+	if chat.Token != nil {
+		if err := a.calendar.RevokeToken(ctx, chat.Token); err != nil {
+			l.Error(fmt.Sprintf("Failed to revoke token: %s", err))
+			c.AbortWithMessage(errorMessage)
+			return
+		}
+	}
+
+	err = a.reminders.DeleteChatReminders(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to delete reminders: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	err = a.contacts.DeleteChatContacts(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to delete contacts: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	err = a.responses.DeleteChatResponses(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to delete attendee responses: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	err = a.bot.DropChatMessages(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to delete outbound messages: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
 
-	err := a.chats.DeleteChatById(c.ChatId)
+	err = a.chats.DeleteChatById(c.ChatId)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to delete chat: %s", err))
 		c.AbortWithMessage(errorMessage)
 		return
 	}
 
-	c.AddMessage("You have successfully unsubscribed from this bot.")
+	c.AddMessage("Your data has been deleted and Google access has been revoked. Use /start if you want to come back.")
 }
 
 func (a *App) HandleEventsCommand(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "command", "/events")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/events")
 
+	a.sendEventsPage(c, l, 0)
+}
+
+func (a *App) HandleEventsCallback(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "callback", "events")
+
+	week := 0
+	if len(c.CallbackArgs) > 0 {
+		var err error
+		week, err = strconv.Atoi(c.CallbackArgs[0])
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to parse week: %s", err))
+			c.AbortWithMessage(errorMessage)
+			return
+		}
+	}
+
+	a.sendEventsPage(c, l, week)
+}
+
+// sendEventsPage sends events of a week relative to the current one with buttons to switch weeks.
+func (a *App) sendEventsPage(c *tgbot.Context, l *slog.Logger, week int) {
 	chat, err := a.chats.GetChatById(c.ChatId)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
@@ -234,33 +415,35 @@ func (a *App) HandleEventsCommand(c *tgbot.Context) {
 		return
 	}
 
-	ctx := context.Background()
-	start := carbon.Now().SubWeeks(2).ToRfc3339String()
-	end := carbon.Now().AddWeeks(1).ToRfc3339String()
+	ctx := c.Context()
+	start := carbon.Now().StartOfDay().AddWeeks(week)
+	end := start.AddWeek()
 
-	eventsList, err := a.calendar.GetEventsList(ctx, *chat.CalendarId, start, end, 100, chat.Token)
+	eventsList, err := a.calendar.GetEventsList(ctx, *chat.CalendarId, start.ToRfc3339String(), end.ToRfc3339String(), 100, a.tokenSource(chat))
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to get events list: %s", err))
 		c.AbortWithMessage(errorMessage)
 		return
 	}
 
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("« Previous week", tgbot.CallbackData("events", strconv.Itoa(week-1))),
+		tgbotapi.NewInlineKeyboardButtonData("Next week »", tgbot.CallbackData("events", strconv.Itoa(week+1))),
+	))
+
+	period := fmt.Sprintf("%s – %s", start.ToStdTime().Format("2 Jan"), end.SubDay().ToStdTime().Format("2 Jan"))
 	if len(eventsList) == 0 {
-		c.AbortWithMessage("You have no upcoming events.")
+		c.AddMessageWithOptions(fmt.Sprintf("You have no events for %s.", period), tgbot.MessageWithOptions{InlineKeyboard: &keyboard})
 		return
 	}
 
-	c.AddMessage("Here are your upcoming events:")
-	for _, event := range eventsList {
-		c.AddMessageWithOptions(a.EventToString(event), tgbot.MessageWithOptions{
-			ParseMode:             tgbotapi.ModeMarkdownV2,
-			DisableWebPagePreview: true,
-		})
+	for _, msg := range agendaMessages(c.ChatId, formatAgenda(fmt.Sprintf("Your events for %s:", period), eventsList, nil), &keyboard) {
+		c.AddMessageConfig(msg)
 	}
 }
 
 func (a *App) HandleNewEventCommand(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "command", "/new")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/new")
 
 	_, err := a.chats.GetChatById(c.ChatId)
 	if err != nil {
@@ -274,7 +457,7 @@ func (a *App) HandleNewEventCommand(c *tgbot.Context) {
 }
 
 func (a *App) HandleNewEventCommandResponse(c *tgbot.Context) {
-	l := a.logger.With("chat_id", c.ChatId, "command", "/new_response")
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/new_response")
 
 	chat, err := a.chats.GetChatById(c.ChatId)
 	if err != nil {
@@ -294,16 +477,25 @@ func (a *App) HandleNewEventCommandResponse(c *tgbot.Context) {
 		Today:       carbon.Now().String(),
 	}
 
-	resp, err := a.gpt.ParseRequest(&req)
+	resp, err := a.gpt.ParseRequest(c.Context(), &req)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to parse request with gpt: %s", err))
 		c.AbortWithMessage(errorMessage)
 		return
 	}
 
+	if resp.Type == gpt.TypeTodo {
+		a.createTodo(c, l, chat, resp)
+		return
+	}
+
 	start := carbon.Parse(resp.Date)
+	if start.Error != nil {
+		l.Warn(fmt.Sprintf("Failed to parse event date %q: %s", resp.Date, start.Error))
+		c.AbortWithMessage("I couldn't understand the date. Please start again /new.")
+		return
+	}
 	end := start.AddMinutes(15)
-	ctx := context.Background()
 
 	e := &gCalendar.Event{
 		// TODO add support for attachments
@@ -313,28 +505,25 @@ func (a *App) HandleNewEventCommandResponse(c *tgbot.Context) {
 		End:         &gCalendar.EventDateTime{DateTime: end.ToRfc3339String()},
 		Summary:     resp.Title,
 	}
-
-	err = a.calendar.CreateEvent(ctx, *chat.CalendarId, e, chat.Token)
-	if err != nil {
-		l.Error(fmt.Sprintf("Failed create a new event: %s", err))
-		c.AbortWithMessage(errorMessage)
-		return
+	if resp.Meet {
+		e.ConferenceData = calendar.NewMeetRequest()
 	}
+	a.addAttendees(c, l, e, resp.Attendees)
 
-	c.AddMessageWithOptions(fmt.Sprintf("I created an event:\n%s", a.EventToString(e)), tgbot.MessageWithOptions{
-		ParseMode:             tgbotapi.ModeMarkdownV2,
-		DisableWebPagePreview: true,
-	})
-
-	err = a.setNextUpdateTimeForChat(ctx, chat)
+	period := calendar.Period{Start: start.ToStdTime(), End: end.ToStdTime()}
+	conflicts, err := a.conflictingEvents(c.Context(), chat, period)
 	if err != nil {
-		l.Error(fmt.Sprintf("Failed setNextUpdateTimeForChat: %s", err))
+		l.Warn(fmt.Sprintf("Failed to check conflicts, creating the event anyway: %s", err))
+	} else if len(conflicts) > 0 {
+		a.proposeAlternatives(c, l, chat, e, period, conflicts)
 		return
 	}
+
+	a.createEvent(c, l, chat, e)
 }
 
 func (a *App) SendMorningAgenda(c *tgbot.Context) {
-	l := a.logger.With("scheduled", "SendMorningAgenda")
+	l := c.Logger(a.logger).With("scheduled", "SendMorningAgenda")
 
 	chats, err := a.chats.GetActiveChats()
 	if err != nil {
@@ -343,36 +532,31 @@ func (a *App) SendMorningAgenda(c *tgbot.Context) {
 	}
 
 	for _, chat := range chats {
-		ctx := context.Background()
-		start := carbon.Now().SubDays(7).ToRfc3339String()
+		ctx := c.Context()
+		start := carbon.Now().StartOfDay().ToRfc3339String()
 		end := carbon.Now().EndOfDay().ToRfc3339String()
 
-		eventsList, err := a.calendar.GetEventsList(ctx, *chat.CalendarId, start, end, 100, chat.Token)
+		eventsList, err := a.calendar.GetEventsList(ctx, *chat.CalendarId, start, end, 100, a.tokenSource(chat))
 		if err != nil {
-			l.Error(fmt.Sprintf("Failed to get events list: %s", err))
-			return
+			l.Error(fmt.Sprintf("Failed to get events list for chat %d: %s", chat.ChatId, err))
+			continue
 		}
 
-		if len(eventsList) == 0 {
-			c.AddMessageConfig(tgbot.CreateMessage(chat.ChatId, "You don't have any tasks for today."))
-			c.Abort()
-			return
-		}
+		todos := a.dueTodos(ctx, l, chat)
 
-		c.AddMessageConfig(tgbot.CreateMessage(chat.ChatId, "Here is your list for today:"))
+		if len(eventsList) == 0 && len(todos) == 0 {
+			c.AddBatchMessageConfig(tgbot.CreateMessage(chat.ChatId, "You don't have any tasks for today."))
+			continue
+		}
 
-		for _, e := range eventsList {
-			msg := tgbot.CreateMessageWithOptions(chat.ChatId, a.EventToString(e), tgbot.MessageWithOptions{
-				ParseMode:             tgbotapi.ModeMarkdownV2,
-				DisableWebPagePreview: true,
-			})
-			c.AddMessageConfig(msg)
+		for _, msg := range agendaMessages(chat.ChatId, formatAgenda("Here is your list for today:", eventsList, todos), nil) {
+			c.AddBatchMessageConfig(msg)
 		}
 	}
 }
 
 func (a *App) SendNotifications(c *tgbot.Context) {
-	l := a.logger.With("scheduled", "SendNotifications")
+	l := c.Logger(a.logger).With("scheduled", "SendNotifications")
 	l.Debug("running")
 
 	chats, err := a.chats.GetActiveChats()
@@ -383,12 +567,22 @@ func (a *App) SendNotifications(c *tgbot.Context) {
 
 	l.Debug(fmt.Sprintf("running notifications for chats %d", len(chats)))
 
+	a.sendDueReminders(c, l)
+
 	for _, chat := range chats {
+		ctx := c.Context()
+		// channels are renewed for every chat, even without upcoming events
+		if expiresAt, ok := channelExpiresAt(chat); ok && time.Until(expiresAt) < channelRenewBefore {
+			if err := a.renewWatchChannel(ctx, chat); err != nil {
+				l.Error(fmt.Sprintf("Failed to renew watch channel: %s", err))
+				c.AddMessageConfig(tgbot.CreateMessage(chat.ChatId, "Something wrong with update channels..."))
+			}
+		}
+
 		if chat.NextUpdateAt != nil && *chat.NextUpdateAt > carbon.Now().Timestamp() {
 			continue
 		}
 
-		ctx := context.Background()
 		if chat.NextEventId == nil {
 			err = a.setNextUpdateTimeForChat(ctx, chat)
 			if err != nil {
@@ -397,53 +591,21 @@ func (a *App) SendNotifications(c *tgbot.Context) {
 			continue
 		}
 
-		e, err := a.calendar.GetEventByID(ctx, *chat.NextEventId, *chat.CalendarId, chat.Token)
+		e, err := a.calendar.GetEventByID(ctx, *chat.NextEventId, *chat.CalendarId, a.tokenSource(chat))
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to get event by id: %s", err))
 			return
 		}
 
-		msg := tgbot.CreateMessageWithOptions(chat.ChatId, fmt.Sprintf("You have a task:\n%s", a.EventToString(e)), tgbot.MessageWithOptions{
-			ParseMode:             tgbotapi.ModeMarkdownV2,
-			DisableWebPagePreview: true,
-		})
-		c.AddMessageConfig(msg)
+		c.AddMessageConfig(a.reminderMessage(chat.ChatId, e))
+		if chat.NextUpdateAt != nil {
+			observeReminder("event", *chat.NextUpdateAt)
+		}
 
 		err = a.setNextUpdateTimeForChat(ctx, chat)
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to set next chat update time: %s", err))
 		}
-
-		if *chat.ChannelExpiration < carbon.Now().Timestamp() {
-			webhookPath := strconv.FormatInt(c.ChatId, 10)
-			channel, err := a.calendar.CreateWatchChannel(ctx, *chat.CalendarId, webhookPath, chat.Token)
-			if err != nil {
-				l.Error(fmt.Sprintf("Failed to create channel: %s", err))
-				msg := tgbot.CreateMessage(chat.ChatId, "Something wrong with update channels...")
-				c.AddMessageConfig(msg)
-				continue
-			}
-
-			err = a.calendar.DeleteWatchChannel(ctx, *chat.CalendarId, *chat.ChannelResourceId, chat.Token)
-			if err != nil {
-				l.Error(fmt.Sprintf("Failed to delete channel: %s", err))
-				msg := tgbot.CreateMessage(chat.ChatId, "Something wrong with update channels...")
-				c.AddMessageConfig(msg)
-				continue
-			}
-
-			chat.ChannelId = &channel.Id
-			chat.ChannelResourceId = &channel.ResourceId
-
-			err = a.chats.UpdateChat(chat)
-			if err != nil {
-				l.Error(fmt.Sprintf("Failed to update chat while renew channel: %s", err))
-				msg := tgbot.CreateMessage(chat.ChatId, "Something wrong with update channels...")
-				c.AddMessageConfig(msg)
-				continue
-			}
-
-		}
 	}
 }
 
@@ -454,7 +616,7 @@ func (a *App) HandleCalendarWebhook(c *gin.Context) {
 	channelId := c.Request.Header.Get("X-Goog-Channel-ID")
 	channelExpiration := c.Request.Header.Get("X-Goog-Channel-Expiration")
 	resourceId := c.Request.Header.Get("X-Goog-Resource-ID")
-	l := a.logger.With("http", "HandleCalendarWebhook",
+	l := requestLogger(c, a.logger).With("http", "HandleCalendarWebhook",
 		"chatId",
 		chatId,
 		"channelId",
@@ -469,24 +631,37 @@ func (a *App) HandleCalendarWebhook(c *gin.Context) {
 	id, err := strconv.ParseInt(chatId, 10, 64)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to parse chatId: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("google", "bad_request").Inc()
 		return
 	}
 	chat, err := a.chats.GetChatById(id)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to get chat by id: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("google", "unknown_chat").Inc()
 		return
 	}
 
 	if chat == nil || chat.CalendarId == nil || chat.Token == nil {
 		l.Error(fmt.Sprintf("chat with id is not configured: %d", chat.ChatId))
+		metrics.WebhookDeliveries.WithLabelValues("google", "not_configured").Inc()
 		return
 	}
 
-	err = a.setNextUpdateTimeForChat(context.Background(), chat)
+	err = a.setNextUpdateTimeForChat(c.Request.Context(), chat)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to setNextUpdateTimeForChat: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("google", "error").Inc()
 		return
 	}
+
+	err = a.syncChanges(c.Request.Context(), chat, l)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to syncChanges: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("google", "error").Inc()
+		return
+	}
+
+	metrics.WebhookDeliveries.WithLabelValues("google", "ok").Inc()
 }
 
 func (a *App) HandleLoginWebhook(c *gin.Context) {
@@ -494,7 +669,7 @@ func (a *App) HandleLoginWebhook(c *gin.Context) {
 
 	chatId := c.Query("state")
 
-	l := a.logger.With("http", "HandleLoginWebhook",
+	l := requestLogger(c, a.logger).With("http", "HandleLoginWebhook",
 		"chatId",
 		chatId)
 	l.Info("login webhook triggered")
@@ -502,27 +677,33 @@ func (a *App) HandleLoginWebhook(c *gin.Context) {
 	id, err := strconv.ParseInt(chatId, 10, 64)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to parse chatId: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("login", "error").Inc()
 		return
 	}
 	chat, err := a.chats.GetChatById(id)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed to get chat by id: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("login", "error").Inc()
 		return
 	}
 
-	token, err := a.calendar.ExchangeCode(context.Background(), c.Query("code"))
+	token, err := a.calendar.ExchangeCode(c.Request.Context(), c.Query("code"))
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed ExchangeCode: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("login", "error").Inc()
 		return
 	}
 
 	chat.Token = token
 	chat.Registered = true
+	chat.NeedsReauth = false
 	err = a.chats.UpdateChat(chat)
 	if err != nil {
 		l.Error(fmt.Sprintf("Failed UpdateChat: %v", err))
+		metrics.WebhookDeliveries.WithLabelValues("login", "error").Inc()
 		return
 	}
+	metrics.WebhookDeliveries.WithLabelValues("login", "ok").Inc()
 	a.bot.SendMessages([]*tgbotapi.MessageConfig{tgbot.CreateMessage(chat.ChatId, "You successfully authenticated! Please use /watch command to subscribe to a calendar.")})
 }
 
@@ -532,20 +713,14 @@ func (a *App) EventToString(event *gCalendar.Event) string {
 		date = event.Start.Date
 	}
 
-	summary := event.Summary
-
-	for _, c := range specialChars {
-		summary = strings.Replace(summary, c, fmt.Sprintf("\\%s", c), -1)
-	}
-
-	return fmt.Sprintf("[%s](%s) \\- %s", summary, event.HtmlLink, carbon.Parse(date).DiffForHumans())
+	return fmt.Sprintf("[%s](%s) \\- %s", escapeMarkdown(event.Summary), event.HtmlLink, carbon.Parse(date).DiffForHumans())
 }
 
 func (a *App) setNextUpdateTimeForChat(ctx context.Context, chat *Chat) error {
 	start := carbon.Now()
 	end := carbon.Now().AddDays(1)
 
-	eventsList, err := a.calendar.GetEventsList(ctx, *chat.CalendarId, start.ToRfc3339String(), end.ToRfc3339String(), 10, chat.Token)
+	eventsList, err := a.calendar.GetEventsList(ctx, *chat.CalendarId, start.ToRfc3339String(), end.ToRfc3339String(), 10, a.tokenSource(chat))
 	if err != nil {
 		return fmt.Errorf("failed to get events list: %w", err)
 	}
@@ -562,7 +737,7 @@ func (a *App) setNextUpdateTimeForChat(ctx context.Context, chat *Chat) error {
 	}
 
 	chat.NextUpdateAt = &t
-	if err := a.chats.UpdateChat(chat); err != nil {
+	if err := a.chats.UpdateNextUpdate(chat.ChatId, chat.NextUpdateAt, chat.NextEventId); err != nil {
 		return fmt.Errorf("failed to update chat: %w", err)
 	}
 
@@ -1,6 +1,8 @@
 package go_plan_it
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/oauth2"
 	"gorm.io/gorm"
@@ -8,21 +10,79 @@ import (
 )
 
 type Chat struct {
-	ChatId     int64 `gorm:"primaryKey;autoIncrement:false"`
-	Registered bool
+	ChatId      int64 `gorm:"primaryKey;autoIncrement:false"`
+	Registered  bool
+	NeedsReauth bool
 
 	ChannelId         *string
 	ChannelExpiration *int64
 	ChannelResourceId *string
-	CalendarId        *string
+	CalendarId        *string `gorm:"serializer:encrypted"`
 	NextUpdateAt      *int64
 	NextEventId       *string
-	Token             *oauth2.Token `gorm:"serializer:json"`
+	Token             *oauth2.Token `gorm:"serializer:encrypted"`
+	// DoneStyle is how an event marked as done is changed in the calendar
+	DoneStyle string
+	// WorkStartHour, WorkEndHour and BufferMinutes limit free slots, defaults are used when nil
+	WorkStartHour *int
+	WorkEndHour   *int
+	BufferMinutes *int
+	// SyncedAt is when changed events were last checked
+	SyncedAt *int64
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+var (
+	ErrChatNotFound = errors.New("chat not found")
+	ErrChatExists   = errors.New("chat already exists")
+)
+
+// ChatRepository stores chats together with their calendar subscription and settings.
+type ChatRepository interface {
+	CreateChat(id int64) (*Chat, error)
+	GetChatById(id int64) (*Chat, error)
+	DeleteChatById(id int64) error
+	UpdateChat(chat *Chat) error
+	UpdateToken(id int64, token *oauth2.Token) error
+	// UpdateSyncedAt saves when changes of the chat calendar were checked last time.
+	UpdateSyncedAt(id int64, syncedAt int64) error
+	// UpdateNextUpdate saves when the chat is checked for the next event and the event.
+	UpdateNextUpdate(id int64, nextUpdateAt *int64, nextEventId *string) error
+	UpdateNeedsReauth(id int64, needsReauth bool) error
+	// UpdateChannel saves the watch channel of the chat calendar.
+	UpdateChannel(id int64, channelId, channelResourceId *string, channelExpiration *int64) error
+	// GetActiveChats returns registered chats subscribed to a calendar.
+	GetActiveChats() ([]*Chat, error)
+	// GetChats returns all chats ordered by id.
+	GetChats() ([]*Chat, error)
+}
+
+const (
+	defaultWorkStartHour = 9
+	defaultWorkEndHour   = 18
+	defaultBufferMinutes = 10
+)
+
+// isGroup reports whether the chat is a group, telegram gives groups negative ids.
+func (chat *Chat) isGroup() bool {
+	return chat.ChatId < 0
+}
+
+// workHours returns working hours and the buffer between events of the chat.
+func (chat *Chat) workHours() (int, int, int) {
+	start, end, buffer := defaultWorkStartHour, defaultWorkEndHour, defaultBufferMinutes
+	if chat.WorkStartHour != nil && chat.WorkEndHour != nil {
+		start, end = *chat.WorkStartHour, *chat.WorkEndHour
+	}
+	if chat.BufferMinutes != nil {
+		buffer = *chat.BufferMinutes
+	}
+	return start, end, buffer
+}
+
+// Chats is a ChatRepository backed by gorm.
 type Chats struct {
 	db *gorm.DB
 }
@@ -41,6 +101,9 @@ func (c *Chats) CreateChat(id int64) (*Chat, error) {
 	}
 
 	if err := c.db.Create(&chat).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, fmt.Errorf("CreateChat: %w", ErrChatExists)
+		}
 		return nil, fmt.Errorf("CreateChat: failed to create chat: %w", err)
 	}
 
@@ -50,6 +113,9 @@ func (c *Chats) CreateChat(id int64) (*Chat, error) {
 func (c *Chats) GetChatById(id int64) (*Chat, error) {
 	var chat Chat
 	if err := c.db.First(&chat, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("GetChatById: %w", ErrChatNotFound)
+		}
 		return nil, fmt.Errorf("GetChatById: failed to get chat: %w", err)
 	}
 
@@ -71,10 +137,93 @@ func (c *Chats) UpdateChat(chat *Chat) error {
 	return nil
 }
 
+func (c *Chats) UpdateToken(id int64, token *oauth2.Token) error {
+	if err := c.db.Model(&Chat{ChatId: id}).Select("token").Updates(&Chat{Token: token}).Error; err != nil {
+		return fmt.Errorf("UpdateToken: failed to save token: %w", err)
+	}
+	return nil
+}
+
+func (c *Chats) UpdateSyncedAt(id int64, syncedAt int64) error {
+	if err := c.db.Model(&Chat{ChatId: id}).Select("synced_at").Updates(&Chat{SyncedAt: &syncedAt}).Error; err != nil {
+		return fmt.Errorf("UpdateSyncedAt: failed to save sync time: %w", err)
+	}
+	return nil
+}
+
+func (c *Chats) UpdateNextUpdate(id int64, nextUpdateAt *int64, nextEventId *string) error {
+	chat := Chat{NextUpdateAt: nextUpdateAt, NextEventId: nextEventId}
+	if err := c.db.Model(&Chat{ChatId: id}).Select("next_update_at", "next_event_id").Updates(&chat).Error; err != nil {
+		return fmt.Errorf("UpdateNextUpdate: failed to save next update: %w", err)
+	}
+	return nil
+}
+
+func (c *Chats) UpdateNeedsReauth(id int64, needsReauth bool) error {
+	if err := c.db.Model(&Chat{ChatId: id}).Select("needs_reauth").Updates(&Chat{NeedsReauth: needsReauth}).Error; err != nil {
+		return fmt.Errorf("UpdateNeedsReauth: failed to save needs reauth: %w", err)
+	}
+	return nil
+}
+
+func (c *Chats) UpdateChannel(id int64, channelId, channelResourceId *string, channelExpiration *int64) error {
+	chat := Chat{ChannelId: channelId, ChannelResourceId: channelResourceId, ChannelExpiration: channelExpiration}
+	if err := c.db.Model(&Chat{ChatId: id}).Select("channel_id", "channel_resource_id", "channel_expiration").Updates(&chat).Error; err != nil {
+		return fmt.Errorf("UpdateChannel: failed to save channel: %w", err)
+	}
+	return nil
+}
+
 func (c *Chats) GetActiveChats() ([]*Chat, error) {
 	chats := make([]*Chat, 0)
-	if err := c.db.Where("registered = ? AND calendar_id IS NOT NULL", true).Find(&chats).Error; err != nil {
+	if err := c.db.Where("registered = ? AND needs_reauth = ? AND calendar_id IS NOT NULL", true, false).Find(&chats).Error; err != nil {
 		return nil, fmt.Errorf("GetActiveChats: failed to get chat: %w", err)
 	}
 	return chats, nil
 }
+
+func (c *Chats) GetChats() ([]*Chat, error) {
+	chats := make([]*Chat, 0)
+	if err := c.db.Order("chat_id").Find(&chats).Error; err != nil {
+		return nil, fmt.Errorf("GetChats: failed to get chats: %w", err)
+	}
+	return chats, nil
+}
+
+// exportSettings returns chat settings and contacts without credentials as indented JSON.
+func exportSettings(chat *Chat, contacts []*Contact) (string, error) {
+	type exportedContact struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+
+	settings := struct {
+		ChatId            int64             `json:"chat_id"`
+		CalendarId        *string           `json:"calendar_id,omitempty"`
+		ChannelExpiration *int64            `json:"channel_expiration,omitempty"`
+		DoneStyle         string            `json:"done_style,omitempty"`
+		WorkStartHour     *int              `json:"work_start_hour,omitempty"`
+		WorkEndHour       *int              `json:"work_end_hour,omitempty"`
+		BufferMinutes     *int              `json:"buffer_minutes,omitempty"`
+		Contacts          []exportedContact `json:"contacts,omitempty"`
+		CreatedAt         string            `json:"created_at"`
+	}{
+		ChatId:            chat.ChatId,
+		CalendarId:        chat.CalendarId,
+		ChannelExpiration: chat.ChannelExpiration,
+		DoneStyle:         chat.DoneStyle,
+		WorkStartHour:     chat.WorkStartHour,
+		WorkEndHour:       chat.WorkEndHour,
+		BufferMinutes:     chat.BufferMinutes,
+		CreatedAt:         chat.CreatedAt.Format(time.RFC3339),
+	}
+	for _, contact := range contacts {
+		settings.Contacts = append(settings.Contacts, exportedContact{Name: contact.Name, Email: contact.Email})
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("exportSettings: failed to marshal settings: %w", err)
+	}
+	return string(data), nil
+}
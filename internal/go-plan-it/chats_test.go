@@ -0,0 +1,193 @@
+package go_plan_it
+
+import (
+	"errors"
+	"golang.org/x/oauth2"
+	"strings"
+	"testing"
+)
+
+// chatRepositories returns the gorm and the memory implementation, both must behave the same.
+func chatRepositories(t *testing.T) map[string]ChatRepository {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]ChatRepository{
+		"gorm":   NewChats(db),
+		"memory": NewMemoryChats(),
+	}
+}
+
+func TestUpdateColumns(t *testing.T) {
+	nextUpdateAt, eventId := int64(2000), "event"
+	channelId, resourceId, expiration := "channel", "resource", int64(3000)
+
+	// updates save a few columns of a chat loaded earlier, by a scheduled job or a webhook
+	updates := []struct {
+		name   string
+		update func(chats ChatRepository, id int64) error
+		check  func(t *testing.T, chat *Chat)
+	}{
+		{
+			name:   "sync time",
+			update: func(chats ChatRepository, id int64) error { return chats.UpdateSyncedAt(id, 1000) },
+			check: func(t *testing.T, chat *Chat) {
+				if chat.SyncedAt == nil || *chat.SyncedAt != 1000 {
+					t.Errorf("SyncedAt = %v, want 1000", chat.SyncedAt)
+				}
+			},
+		},
+		{
+			name: "next update",
+			update: func(chats ChatRepository, id int64) error {
+				return chats.UpdateNextUpdate(id, &nextUpdateAt, &eventId)
+			},
+			check: func(t *testing.T, chat *Chat) {
+				if chat.NextUpdateAt == nil || *chat.NextUpdateAt != nextUpdateAt || chat.NextEventId == nil || *chat.NextEventId != eventId {
+					t.Errorf("next update = %v, %v, want %d, %s", chat.NextUpdateAt, chat.NextEventId, nextUpdateAt, eventId)
+				}
+			},
+		},
+		{
+			name:   "needs reauth",
+			update: func(chats ChatRepository, id int64) error { return chats.UpdateNeedsReauth(id, true) },
+			check: func(t *testing.T, chat *Chat) {
+				if !chat.NeedsReauth {
+					t.Errorf("NeedsReauth = false, want true")
+				}
+			},
+		},
+		{
+			name: "channel",
+			update: func(chats ChatRepository, id int64) error {
+				return chats.UpdateChannel(id, &channelId, &resourceId, &expiration)
+			},
+			check: func(t *testing.T, chat *Chat) {
+				if chat.ChannelId == nil || *chat.ChannelId != channelId ||
+					chat.ChannelResourceId == nil || *chat.ChannelResourceId != resourceId ||
+					chat.ChannelExpiration == nil || *chat.ChannelExpiration != expiration {
+					t.Errorf("channel = %v, %v, %v, want %s, %s, %d", chat.ChannelId, chat.ChannelResourceId, chat.ChannelExpiration, channelId, resourceId, expiration)
+				}
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		// change runs between loading the chat and the update, like a command handled concurrently
+		change func(t *testing.T, chats ChatRepository, id int64)
+		check  func(t *testing.T, chat *Chat, err error)
+	}{
+		{
+			name:   "column is saved",
+			change: func(t *testing.T, chats ChatRepository, id int64) {},
+			check: func(t *testing.T, chat *Chat, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "concurrent changes are kept",
+			change: func(t *testing.T, chats ChatRepository, id int64) {
+				chat, err := chats.GetChatById(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				start := 8
+				chat.DoneStyle = doneStyleColor
+				chat.WorkStartHour = &start
+				if err := chats.UpdateChat(chat); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, chat *Chat, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if chat.DoneStyle != doneStyleColor || chat.WorkStartHour == nil || *chat.WorkStartHour != 8 {
+					t.Errorf("settings are overwritten: done style %q, work start %v", chat.DoneStyle, chat.WorkStartHour)
+				}
+				if chat.CalendarId == nil || *chat.CalendarId != "primary" {
+					t.Errorf("CalendarId = %v, want primary", chat.CalendarId)
+				}
+			},
+		},
+		{
+			name: "deleted chat is not re-created",
+			change: func(t *testing.T, chats ChatRepository, id int64) {
+				if err := chats.DeleteChatById(id); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, chat *Chat, err error) {
+				if !errors.Is(err, ErrChatNotFound) {
+					t.Errorf("GetChatById() = %+v, %v, want ErrChatNotFound", chat, err)
+				}
+			},
+		},
+	}
+
+	for name, chats := range chatRepositories(t) {
+		id := int64(0)
+		for _, update := range updates {
+			for _, tt := range tests {
+				t.Run(name+"/"+update.name+"/"+tt.name, func(t *testing.T) {
+					id++
+					chat, err := chats.CreateChat(id)
+					if err != nil {
+						t.Fatal(err)
+					}
+					calendarId := "primary"
+					chat.Registered = true
+					chat.CalendarId = &calendarId
+					if err := chats.UpdateChat(chat); err != nil {
+						t.Fatal(err)
+					}
+
+					tt.change(t, chats, id)
+					// the chat may be deleted, only the stored row matters
+					_ = update.update(chats, id)
+
+					chat, err = chats.GetChatById(id)
+					tt.check(t, chat, err)
+					if err == nil {
+						update.check(t, chat)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestExportSettings(t *testing.T) {
+	calendarId := "primary"
+	start, end, buffer := 8, 17, 15
+	chat := &Chat{
+		ChatId:        1,
+		CalendarId:    &calendarId,
+		Token:         &oauth2.Token{AccessToken: "access"},
+		DoneStyle:     doneStyleColor,
+		WorkStartHour: &start,
+		WorkEndHour:   &end,
+		BufferMinutes: &buffer,
+	}
+
+	contacts := []*Contact{{ChatId: 1, Name: "Ann", Email: "ann@example.com"}}
+
+	settings, err := exportSettings(chat, contacts)
+	if err != nil {
+		t.Fatalf("exportSettings() error = %v", err)
+	}
+
+	for _, want := range []string{`"calendar_id": "primary"`, `"done_style": "color"`, `"work_start_hour": 8`, `"work_end_hour": 17`, `"buffer_minutes": 15`, `"email": "ann@example.com"`} {
+		if !strings.Contains(settings, want) {
+			t.Errorf("settings don't contain %s:\n%s", want, settings)
+		}
+	}
+	if strings.Contains(settings, "access") {
+		t.Errorf("settings contain the token:\n%s", settings)
+	}
+}
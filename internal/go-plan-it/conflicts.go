@@ -0,0 +1,110 @@
+package go_plan_it
+
+import (
+	"context"
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/calendar"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gCalendar "google.golang.org/api/calendar/v3"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	maxConflicts      = 3
+	maxAlternatives   = 2
+	conflictsPageSize = 50
+)
+
+// isBusy reports whether the event blocks the user's time.
+func isBusy(event *gCalendar.Event) bool {
+	if event.Status == "cancelled" || event.Transparency == "transparent" {
+		return false
+	}
+	if event.Start == nil || event.Start.DateTime == "" {
+		// all day events are reminders and holidays rather than meetings
+		return false
+	}
+	for _, attendee := range event.Attendees {
+		if attendee.Self && attendee.ResponseStatus == "declined" {
+			return false
+		}
+	}
+	return true
+}
+
+// conflictingEvents returns events of the chat calendars which overlap the period.
+func (a *App) conflictingEvents(ctx context.Context, chat *Chat, period calendar.Period) ([]*gCalendar.Event, error) {
+	conflicts := make([]*gCalendar.Event, 0)
+	seen := make(map[string]bool)
+
+	for _, id := range calendarIds(chat) {
+		events, err := a.calendar.GetEventsList(ctx, id, period.Start.Format(time.RFC3339), period.End.Format(time.RFC3339), conflictsPageSize, a.tokenSource(chat))
+		if err != nil {
+			return nil, fmt.Errorf("conflictingEvents: %w", err)
+		}
+
+		for _, event := range events {
+			if !isBusy(event) || seen[event.ICalUID] {
+				continue
+			}
+			start, end, _, err := eventTime(event)
+			if err != nil || !period.Overlaps(calendar.Period{Start: start, End: end}) {
+				continue
+			}
+			seen[event.ICalUID] = true
+			conflicts = append(conflicts, event)
+		}
+	}
+
+	return conflicts, nil
+}
+
+func formatConflicts(conflicts []*gCalendar.Event) string {
+	parts := make([]string, 0, maxConflicts)
+	for i, event := range conflicts {
+		if i == maxConflicts {
+			parts = append(parts, fmt.Sprintf("%d more", len(conflicts)-maxConflicts))
+			break
+		}
+		start, end, _, _ := eventTime(event)
+		summary := event.Summary
+		if summary == "" {
+			summary = "(no title)"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s–%s", summary, start.Format(timeLayout), end.Format(timeLayout)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// proposeAlternatives warns about overlapping events and offers to create the
+// event anyway or to move it to one of the next free slots.
+func (a *App) proposeAlternatives(c *tgbot.Context, l *slog.Logger, chat *Chat, e *gCalendar.Event, period calendar.Period, conflicts []*gCalendar.Event) {
+	p := &proposal{
+		chatId:    c.ChatId,
+		title:     e.Summary,
+		notes:     e.Description,
+		attendees: e.Attendees,
+		meet:      e.ConferenceData != nil,
+		slots:     []calendar.Period{period},
+	}
+	labels := []string{fmt.Sprintf("Create anyway at %s", period.Start.In(time.Local).Format(slotLayout))}
+
+	window := calendar.Period{Start: latest(period.Start, time.Now()), End: period.Start.AddDate(0, 0, 7)}
+	slots, err := a.findSlots(c.Context(), chat, window, period.End.Sub(period.Start))
+	if err != nil {
+		// the user can still create the event at the requested time
+		l.Warn(fmt.Sprintf("Failed to find free slots: %s", err))
+	}
+
+	for i, slot := range slots {
+		if i == maxAlternatives {
+			break
+		}
+		p.slots = append(p.slots, slot)
+		labels = append(labels, fmt.Sprintf("Move to %s", slotLabel(slot)))
+	}
+
+	a.proposeSlots(c, l, fmt.Sprintf("%s overlaps with %s.", e.Summary, formatConflicts(conflicts)), p, labels)
+}
@@ -0,0 +1,210 @@
+package go_plan_it
+
+import (
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gCalendar "google.golang.org/api/calendar/v3"
+	"gorm.io/gorm"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Contact is an entry of the chat address book used to invite people by name.
+type Contact struct {
+	Id        uint64 `gorm:"primaryKey"`
+	ChatId    int64  `gorm:"index"`
+	Name      string
+	Email     string `gorm:"serializer:encrypted"`
+	CreatedAt time.Time
+}
+
+type ContactRepository interface {
+	CreateContact(contact *Contact) error
+	UpdateContact(contact *Contact) error
+	// GetChatContacts returns contacts of the chat ordered by name.
+	GetChatContacts(chatId int64) ([]*Contact, error)
+	DeleteContact(id uint64) error
+	DeleteChatContacts(chatId int64) error
+}
+
+// Contacts is a ContactRepository backed by gorm.
+type Contacts struct {
+	db *gorm.DB
+}
+
+func NewContacts(db *gorm.DB) *Contacts {
+	return &Contacts{db: db}
+}
+
+func (r *Contacts) CreateContact(contact *Contact) error {
+	if err := r.db.Create(contact).Error; err != nil {
+		return fmt.Errorf("CreateContact: failed to create contact: %w", err)
+	}
+	return nil
+}
+
+func (r *Contacts) UpdateContact(contact *Contact) error {
+	if err := r.db.Save(contact).Error; err != nil {
+		return fmt.Errorf("UpdateContact: failed to update contact: %w", err)
+	}
+	return nil
+}
+
+func (r *Contacts) GetChatContacts(chatId int64) ([]*Contact, error) {
+	contacts := make([]*Contact, 0)
+	if err := r.db.Where("chat_id = ?", chatId).Order("name").Find(&contacts).Error; err != nil {
+		return nil, fmt.Errorf("GetChatContacts: failed to get contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+func (r *Contacts) DeleteContact(id uint64) error {
+	if err := r.db.Delete(&Contact{}, id).Error; err != nil {
+		return fmt.Errorf("DeleteContact: failed to delete contact: %w", err)
+	}
+	return nil
+}
+
+func (r *Contacts) DeleteChatContacts(chatId int64) error {
+	if err := r.db.Where("chat_id = ?", chatId).Delete(&Contact{}).Error; err != nil {
+		return fmt.Errorf("DeleteChatContacts: failed to delete contacts: %w", err)
+	}
+	return nil
+}
+
+func findContact(contacts []*Contact, name string) *Contact {
+	for _, contact := range contacts {
+		if strings.EqualFold(contact.Name, name) {
+			return contact
+		}
+	}
+	return nil
+}
+
+// resolveAttendees turns names and emails into event attendees, names are looked up
+// in the contacts. The names which are not found are returned as unknown.
+func resolveAttendees(contacts []*Contact, people []string) ([]*gCalendar.EventAttendee, []string) {
+	attendees := make([]*gCalendar.EventAttendee, 0, len(people))
+	unknown := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, person := range people {
+		person = strings.TrimSpace(person)
+		if person == "" {
+			continue
+		}
+
+		var email string
+		if address, err := mail.ParseAddress(person); err == nil {
+			email = address.Address
+		} else if contact := findContact(contacts, person); contact != nil {
+			email = contact.Email
+		} else {
+			unknown = append(unknown, person)
+			continue
+		}
+
+		email = strings.ToLower(email)
+		if !seen[email] {
+			seen[email] = true
+			attendees = append(attendees, &gCalendar.EventAttendee{Email: email})
+		}
+	}
+
+	return attendees, unknown
+}
+
+// addAttendees resolves people mentioned in the request and adds them to the event.
+func (a *App) addAttendees(c *tgbot.Context, l *slog.Logger, e *gCalendar.Event, people []string) {
+	if len(people) == 0 {
+		return
+	}
+
+	contacts, err := a.contacts.GetChatContacts(c.ChatId)
+	if err != nil {
+		// the event is still useful without invitations
+		l.Error(fmt.Sprintf("Failed to get contacts: %s", err))
+		c.AddMessage("I couldn't load your contacts, nobody is invited.")
+		return
+	}
+
+	attendees, unknown := resolveAttendees(contacts, people)
+	e.Attendees = attendees
+
+	if len(unknown) > 0 {
+		c.AddMessage(fmt.Sprintf("I don't know the email of %s, so I didn't invite them. "+
+			"Add it with /contacts add <name> <email>.", strings.Join(unknown, ", ")))
+	}
+}
+
+func (a *App) HandleContactsCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/contacts")
+
+	contacts, err := a.contacts.GetChatContacts(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get contacts: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	action, args, _ := strings.Cut(strings.TrimSpace(c.Update.Message.CommandArguments()), " ")
+	args = strings.TrimSpace(args)
+
+	switch action {
+	case "":
+		if len(contacts) == 0 {
+			c.AddMessage("You have no contacts. Use /contacts add <name> <email> to add one.")
+			return
+		}
+
+		lines := make([]string, 0, len(contacts))
+		for _, contact := range contacts {
+			lines = append(lines, fmt.Sprintf("• %s <%s>", contact.Name, contact.Email))
+		}
+		c.AddMessage(fmt.Sprintf("Your contacts:\n%s\n\nUse /contacts add <name> <email> or /contacts remove <name> to change them.", strings.Join(lines, "\n")))
+
+	case "add":
+		i := strings.LastIndex(args, " ")
+		if i < 0 {
+			c.AbortWithMessage("Wrong format. Use /contacts add <name> <email>.")
+			return
+		}
+		name := strings.TrimSpace(args[:i])
+		address, err := mail.ParseAddress(args[i+1:])
+		if name == "" || err != nil {
+			c.AbortWithMessage("Wrong format. Use /contacts add <name> <email>.")
+			return
+		}
+
+		if contact := findContact(contacts, name); contact != nil {
+			contact.Email = address.Address
+			err = a.contacts.UpdateContact(contact)
+		} else {
+			err = a.contacts.CreateContact(&Contact{ChatId: c.ChatId, Name: name, Email: address.Address})
+		}
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to save contact: %s", err))
+			c.AbortWithMessage(errorMessage)
+			return
+		}
+		c.AddMessage(fmt.Sprintf("Saved %s <%s>.", name, address.Address))
+
+	case "remove":
+		contact := findContact(contacts, args)
+		if contact == nil {
+			c.AbortWithMessage(fmt.Sprintf("There is no contact %s.", args))
+			return
+		}
+		if err := a.contacts.DeleteContact(contact.Id); err != nil {
+			l.Error(fmt.Sprintf("Failed to delete contact: %s", err))
+			c.AbortWithMessage(errorMessage)
+			return
+		}
+		c.AddMessage(fmt.Sprintf("Removed %s.", contact.Name))
+
+	default:
+		c.AbortWithMessage("Unknown action. Use /contacts, /contacts add <name> <email> or /contacts remove <name>.")
+	}
+}
@@ -1,18 +1,134 @@
 package go_plan_it
 
 import (
+	"context"
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/keyring"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite" // Sqlite driver based on CGO
 	"gorm.io/gorm"
+	"reflect"
+	"slices"
+	"strings"
 )
 
-func NewDB() (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open("gorm.db"), &gorm.Config{TranslateError: true})
+// NewDB opens the database, applies pending migrations and re-encrypts
+// sensitive fields with the active key.
+func NewDB(config config.Database, k *keyring.Keyring) (*gorm.DB, error) {
+	RegisterEncryptedSerializer(k)
+
+	db, err := OpenDB(config)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = db.AutoMigrate(&Chat{}); err != nil {
+	if _, err = NewMigrator(db).Up(); err != nil {
+		return nil, err
+	}
+
+	if err = ReencryptData(db, k); err != nil {
 		return nil, err
 	}
 	return db, nil
 }
+
+// OpenDB opens the database. PostgreSQL is used for "postgres://" URLs and
+// "host=..." connection strings, otherwise the DSN is a path to a SQLite file.
+func OpenDB(config config.Database) (*gorm.DB, error) {
+	if config.DSN == "" {
+		return nil, fmt.Errorf("OpenDB: database dsn is not set")
+	}
+
+	return gorm.Open(dialector(config.DSN), &gorm.Config{TranslateError: true})
+}
+
+func CloseDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("CloseDB: %w", err)
+	}
+	return sqlDB.Close()
+}
+
+func PingDB(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("PingDB: %w", err)
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func dialector(dsn string) gorm.Dialector {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"),
+		strings.HasPrefix(dsn, "postgresql://"),
+		strings.Contains(dsn, "host="):
+		return postgres.Open(dsn)
+	default:
+		return sqlite.Open(dsn)
+	}
+}
+
+// encryptedModels are the models with fields stored by the encrypted serializer.
+var encryptedModels = []any{&Chat{}, &Contact{}, &AttendeeResponse{}, &OutboundMessage{}}
+
+// ReencryptData rewrites encrypted fields of every model which are stored in plain text
+// or encrypted with a key that is no longer active.
+func ReencryptData(db *gorm.DB, k *keyring.Keyring) error {
+	for _, model := range encryptedModels {
+		if err := reencrypt(db, k, model); err != nil {
+			return fmt.Errorf("ReencryptData: %w", err)
+		}
+	}
+	return nil
+}
+
+func reencrypt(db *gorm.DB, k *keyring.Keyring, model any) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return fmt.Errorf("failed to parse %T: %w", model, err)
+	}
+
+	primaryKey := stmt.Schema.PrioritizedPrimaryField.DBName
+	columns := make([]string, 0)
+	for _, field := range stmt.Schema.Fields {
+		if field.TagSettings["SERIALIZER"] == encryptedSerializer {
+			columns = append(columns, field.DBName)
+		}
+	}
+
+	// raw values are read, decrypting them would fail for keys which are not in the keyring
+	var rows []map[string]any
+	if err := db.Table(stmt.Schema.Table).Select(append([]string{primaryKey}, columns...)).Find(&rows).Error; err != nil {
+		return fmt.Errorf("failed to read %s: %w", stmt.Schema.Table, err)
+	}
+
+	for _, row := range rows {
+		if !slices.ContainsFunc(columns, func(column string) bool { return needsRotation(k, row[column]) }) {
+			continue
+		}
+
+		record := reflect.New(stmt.Schema.ModelType).Interface()
+		if err := db.First(record, row[primaryKey]).Error; err != nil {
+			return fmt.Errorf("failed to get %s %v: %w", stmt.Schema.Table, row[primaryKey], err)
+		}
+
+		if err := db.Model(record).Select(columns).Updates(record).Error; err != nil {
+			return fmt.Errorf("failed to update %s %v: %w", stmt.Schema.Table, row[primaryKey], err)
+		}
+	}
+
+	return nil
+}
+
+func needsRotation(k *keyring.Keyring, value any) bool {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	}
+	return s != "" && k.NeedsRotation(s)
+}
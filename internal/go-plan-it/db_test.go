@@ -0,0 +1,120 @@
+package go_plan_it
+
+import (
+	"encoding/base64"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/keyring"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openKeyedDB opens the database with the serializer using keys, a new connection
+// is needed after the keys change because gorm caches the serializer with the schema.
+func openKeyedDB(t *testing.T, dsn string, keys ...string) (*gorm.DB, *keyring.Keyring) {
+	t.Helper()
+
+	for i, id := range keys {
+		keys[i] = id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], 32)))
+	}
+	k, err := keyring.ParseKeys(strings.Join(keys, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterEncryptedSerializer(k)
+
+	db, err := OpenDB(config.Database{DSN: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = CloseDB(db) })
+	return db, k
+}
+
+func TestReencryptData(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	calendarId := "primary"
+
+	db, _ := openKeyedDB(t, dsn, "old")
+	if _, err := NewMigrator(db).Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []any{
+		&Chat{ChatId: 1, CalendarId: &calendarId, Token: &oauth2.Token{AccessToken: "access"}},
+		&Contact{ChatId: 1, Name: "Ann", Email: "ann@example.com"},
+		&AttendeeResponse{ChatId: 1, EventId: "event", Email: "bob@example.com", ResponseStatus: "accepted"},
+		&OutboundMessage{ChatId: 1, Text: "hello"},
+	} {
+		if err := db.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = CloseDB(db)
+
+	db, k := openKeyedDB(t, dsn, "new", "old")
+	if err := ReencryptData(db, k); err != nil {
+		t.Fatalf("ReencryptData() error = %v", err)
+	}
+	_ = CloseDB(db)
+
+	// the old key is removed after the rotation
+	db, k = openKeyedDB(t, dsn, "new")
+
+	for _, model := range encryptedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.TagSettings["SERIALIZER"] != encryptedSerializer {
+				continue
+			}
+			var values []string
+			if err := db.Table(stmt.Schema.Table).Pluck(field.DBName, &values).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(values) == 0 {
+				t.Errorf("%s has no rows", stmt.Schema.Table)
+			}
+			for _, value := range values {
+				if k.NeedsRotation(value) {
+					t.Errorf("%s.%s is not encrypted with the active key: %q", stmt.Schema.Table, field.DBName, value)
+				}
+			}
+		}
+	}
+
+	chat, err := NewChats(db).GetChatById(1)
+	if err != nil {
+		t.Fatalf("GetChatById() error = %v", err)
+	}
+	if *chat.CalendarId != calendarId || chat.Token.AccessToken != "access" {
+		t.Errorf("chat = %v, %v, want %s and the token", *chat.CalendarId, chat.Token, calendarId)
+	}
+
+	contacts, err := NewContacts(db).GetChatContacts(1)
+	if err != nil {
+		t.Fatalf("GetChatContacts() error = %v", err)
+	}
+	if len(contacts) != 1 || contacts[0].Email != "ann@example.com" {
+		t.Errorf("contacts = %v, want ann@example.com", contacts)
+	}
+
+	responses, err := NewAttendeeResponses(db).GetEventResponses(1, "event")
+	if err != nil {
+		t.Fatalf("GetEventResponses() error = %v", err)
+	}
+	if len(responses) != 1 || responses[0].Email != "bob@example.com" {
+		t.Errorf("responses = %v, want bob@example.com", responses)
+	}
+
+	messages, err := NewOutbox(db).GetOutboundMessages()
+	if err != nil {
+		t.Fatalf("GetOutboundMessages() error = %v", err)
+	}
+	if len(messages) != 1 || messages[0].Text != "hello" {
+		t.Errorf("messages = %v, want hello", messages)
+	}
+}
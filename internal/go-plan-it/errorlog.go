@@ -0,0 +1,118 @@
+package go_plan_it
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorRecord is an error logged by the app.
+type ErrorRecord struct {
+	Time    time.Time
+	Message string
+}
+
+// RecentErrors keeps the last logged errors in memory for the admin page.
+type RecentErrors struct {
+	mu      sync.Mutex
+	size    int
+	records []ErrorRecord
+	// replaceAttr rewrites the message and attributes before they are remembered
+	replaceAttr func(groups []string, a slog.Attr) slog.Attr
+}
+
+// NewRecentErrors remembers the last size errors, the optional replaceAttr is applied to them like in slog.HandlerOptions.
+func NewRecentErrors(size int, replaceAttr ...func(groups []string, a slog.Attr) slog.Attr) *RecentErrors {
+	r := &RecentErrors{size: size, records: make([]ErrorRecord, 0, size)}
+	if len(replaceAttr) > 0 {
+		r.replaceAttr = replaceAttr[0]
+	}
+	return r
+}
+
+// format returns the attribute as " key=value", replaceAttr is applied if it is set.
+func (r *RecentErrors) format(group string, attr slog.Attr) string {
+	if r.replaceAttr != nil {
+		attr = r.replaceAttr(nil, attr)
+	}
+	return fmt.Sprintf(" %s%s=%v", group, attr.Key, attr.Value)
+}
+
+// Handler wraps next, records at the error level are remembered before being passed on.
+func (r *RecentErrors) Handler(next slog.Handler) slog.Handler {
+	return &recentErrorsHandler{next: next, recent: r}
+}
+
+func (r *RecentErrors) add(record ErrorRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.records) == r.size {
+		copy(r.records, r.records[1:])
+		r.records = r.records[:len(r.records)-1]
+	}
+	r.records = append(r.records, record)
+}
+
+// Records returns remembered errors, the newest first.
+func (r *RecentErrors) Records() []ErrorRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]ErrorRecord, 0, len(r.records))
+	for i := len(r.records) - 1; i >= 0; i-- {
+		records = append(records, r.records[i])
+	}
+	return records
+}
+
+type recentErrorsHandler struct {
+	next   slog.Handler
+	recent *RecentErrors
+	// attrs are added with WithAttrs, they are kept in the message for context
+	attrs string
+	group string
+}
+
+func (h *recentErrorsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelError || h.next.Enabled(ctx, level)
+}
+
+func (h *recentErrorsHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelError {
+		message := slog.String(slog.MessageKey, record.Message)
+		if h.recent.replaceAttr != nil {
+			message = h.recent.replaceAttr(nil, message)
+		}
+
+		var b strings.Builder
+		b.WriteString(message.Value.String())
+		b.WriteString(h.attrs)
+		record.Attrs(func(attr slog.Attr) bool {
+			b.WriteString(h.recent.format(h.group, attr))
+			return true
+		})
+		h.recent.add(ErrorRecord{Time: record.Time, Message: b.String()})
+	}
+
+	if !h.next.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *recentErrorsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, attr := range attrs {
+		b.WriteString(h.recent.format(h.group, attr))
+	}
+	return &recentErrorsHandler{next: h.next.WithAttrs(attrs), recent: h.recent, attrs: b.String(), group: h.group}
+}
+
+func (h *recentErrorsHandler) WithGroup(name string) slog.Handler {
+	return &recentErrorsHandler{next: h.next.WithGroup(name), recent: h.recent, attrs: h.attrs, group: h.group + name + "."}
+}
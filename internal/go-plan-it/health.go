@@ -0,0 +1,118 @@
+package go_plan_it
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/ibovyrin/go-plan-it/pkg/gpt"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	"gorm.io/gorm"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+	// llmCheckInterval limits how often the LLM backend is called by readiness probes
+	llmCheckInterval = time.Minute
+)
+
+// healthCheck returns an error if a dependency of the bot is not healthy.
+type healthCheck func(ctx context.Context) error
+
+// Health serves liveness and readiness probes of the bot.
+type Health struct {
+	db     *gorm.DB
+	bot    *tgbot.Bot
+	gpt    *gpt.GPT
+	logger *slog.Logger
+
+	llmMu        sync.Mutex
+	llmCheckedAt time.Time
+	llmErr       error
+}
+
+func NewHealth(db *gorm.DB, bot *tgbot.Bot, gpt *gpt.GPT, logger *slog.Logger) *Health {
+	return &Health{
+		db:     db,
+		bot:    bot,
+		gpt:    gpt,
+		logger: logger,
+	}
+}
+
+// HandleHealthz reports whether the bot is alive: updates are handled and scheduled jobs are not wedged.
+func (h *Health) HandleHealthz(c *gin.Context) {
+	h.respond(c, map[string]healthCheck{
+		"updates":   func(context.Context) error { return h.bot.CheckUpdatesHandler() },
+		"scheduler": func(context.Context) error { return h.bot.CheckScheduler() },
+	})
+}
+
+// HandleReadyz reports whether the bot can serve requests: the database, Telegram and the LLM backend are
+// reachable and the scheduler is running.
+func (h *Health) HandleReadyz(c *gin.Context) {
+	h.respond(c, map[string]healthCheck{
+		"db": func(ctx context.Context) error {
+			return PingDB(ctx, h.db)
+		},
+		"telegram":  h.bot.CheckClient,
+		"updates":   func(context.Context) error { return h.bot.CheckUpdatesHandler() },
+		"scheduler": func(context.Context) error { return h.bot.CheckScheduler() },
+		"llm":       h.checkLLM,
+	})
+}
+
+// checkLLM pings the LLM backend at most once per llmCheckInterval and returns the last result otherwise.
+func (h *Health) checkLLM(ctx context.Context) error {
+	h.llmMu.Lock()
+	defer h.llmMu.Unlock()
+
+	if !h.llmCheckedAt.IsZero() && time.Since(h.llmCheckedAt) < llmCheckInterval {
+		return h.llmErr
+	}
+
+	h.llmErr = h.gpt.Ping(ctx)
+	h.llmCheckedAt = time.Now()
+	return h.llmErr
+}
+
+// respond runs checks concurrently and responds with 503 if any of them fails.
+func (h *Health) respond(c *gin.Context, checks map[string]healthCheck) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]string, len(checks))
+	status := http.StatusOK
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check healthCheck) {
+			defer wg.Done()
+
+			result := "ok"
+			if err := check(ctx); err != nil {
+				requestLogger(c, h.logger).Warn(fmt.Sprintf("Health check %s failed: %s", name, err), "path", c.FullPath())
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if result != "ok" {
+				status = http.StatusServiceUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	text := "ok"
+	if status != http.StatusOK {
+		text = "unavailable"
+	}
+	c.JSON(status, gin.H{"status": text, "checks": results})
+}
@@ -0,0 +1,92 @@
+package go_plan_it
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ibovyrin/go-plan-it/pkg/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+var tracer = otel.Tracer("github.com/ibovyrin/go-plan-it/internal/go-plan-it")
+
+// validRequestId limits request ids taken from the X-Request-Id header.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// probes are requested every few seconds, they are logged at the debug level.
+var probes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// RequestLogger assigns a correlation id to HTTP requests, or keeps the one from the X-Request-Id
+// header, and logs requests without query strings, which contain OAuth codes.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(requestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId = logging.NewRequestId()
+		}
+		c.Set(logging.RequestIdKey, requestId)
+		c.Header(requestIdHeader, requestId)
+
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if probes[c.Request.URL.Path] {
+			level = slog.LevelDebug
+		}
+		logger.Log(c.Request.Context(), level, "http request",
+			logging.RequestIdKey, requestId,
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"remote_addr", c.ClientIP(),
+		)
+	}
+}
+
+// TraceRequests starts a span for HTTP requests, the trace is continued if the request has the traceparent header.
+func TraceRequests(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		route = "unknown"
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.method", c.Request.Method),
+		attribute.String("http.route", route),
+		attribute.String(logging.RequestIdKey, c.GetString(logging.RequestIdKey)),
+	))
+	defer span.End()
+
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// requestLogger returns logger with the correlation id and the trace id of the HTTP request.
+func requestLogger(c *gin.Context, logger *slog.Logger) *slog.Logger {
+	logger = logger.With(logging.RequestIdKey, c.GetString(logging.RequestIdKey))
+	if span := trace.SpanContextFromContext(c.Request.Context()); span.HasTraceID() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	return logger
+}
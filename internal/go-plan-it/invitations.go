@@ -0,0 +1,135 @@
+package go_plan_it
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/calendar"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gCalendar "google.golang.org/api/calendar/v3"
+	"strings"
+	"time"
+)
+
+// rsvpResponses maps callback arguments to attendee response statuses.
+var rsvpResponses = map[string]string{
+	"a": "accepted",
+	"t": "tentative",
+	"d": "declined",
+}
+
+func selfAttendee(event *gCalendar.Event) *gCalendar.EventAttendee {
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			return attendee
+		}
+	}
+	return nil
+}
+
+// newInvitation reports whether the event is an invitation the user hasn't responded
+// to and hasn't been told about yet. The invitation is remembered as reported.
+func (a *App) newInvitation(chat *Chat, event *gCalendar.Event) (bool, error) {
+	if event.Organizer == nil || event.Organizer.Self || event.Status == "cancelled" {
+		return false, nil
+	}
+
+	self := selfAttendee(event)
+	if self == nil || self.ResponseStatus != responseNeedsAction {
+		return false, nil
+	}
+
+	if _, end, _, err := eventTime(event); err == nil && end.Before(time.Now()) {
+		return false, nil
+	}
+
+	stored, err := a.responses.GetEventResponses(chat.ChatId, event.Id)
+	if err != nil {
+		return false, err
+	}
+	for _, response := range stored {
+		if strings.EqualFold(response.Email, self.Email) {
+			return false, nil
+		}
+	}
+
+	err = a.responses.SaveResponse(&AttendeeResponse{
+		ChatId:         chat.ChatId,
+		EventId:        event.Id,
+		Email:          strings.ToLower(self.Email),
+		ResponseStatus: self.ResponseStatus,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *App) invitationMessage(chatId int64, event *gCalendar.Event) *tgbotapi.MessageConfig {
+	organizer := event.Organizer.DisplayName
+	if organizer == "" {
+		organizer = event.Organizer.Email
+	}
+
+	line := a.EventToString(event)
+	if start, end, allDay, err := eventTime(event); err == nil {
+		line = fmt.Sprintf("*%s*\n%s", escapeMarkdown(start.Format(dayLayout)), formatEventLine(event, start, end, allDay))
+	}
+	text := fmt.Sprintf("%s invited you:\n%s", escapeMarkdown(organizer), line)
+
+	options := tgbot.MessageWithOptions{
+		ParseMode:             tgbotapi.ModeMarkdownV2,
+		DisableWebPagePreview: true,
+	}
+
+	if len(tgbot.CallbackData("rsvp", event.Id, "a")) <= tgbot.MaxCallbackDataLength {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Accept", tgbot.CallbackData("rsvp", event.Id, "a")),
+			tgbotapi.NewInlineKeyboardButtonData("Maybe", tgbot.CallbackData("rsvp", event.Id, "t")),
+			tgbotapi.NewInlineKeyboardButtonData("Decline", tgbot.CallbackData("rsvp", event.Id, "d")),
+		))
+		options.InlineKeyboard = &keyboard
+	}
+
+	return tgbot.CreateMessageWithOptions(chatId, text, options)
+}
+
+func (a *App) HandleRSVPCallback(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "callback", "rsvp")
+
+	if len(c.CallbackArgs) != 2 {
+		l.Error(fmt.Sprintf("Wrong callback args: %v", c.CallbackArgs))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	status, ok := rsvpResponses[c.CallbackArgs[1]]
+	if !ok {
+		l.Error(fmt.Sprintf("Unknown response: %s", c.CallbackArgs[1]))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	event, err := a.calendar.RespondToEvent(c.Context(), *chat.CalendarId, c.CallbackArgs[0], status, a.tokenSource(chat))
+	if errors.Is(err, calendar.ErrNotAttendee) {
+		c.AbortWithMessage("You are no longer invited to this event.")
+		return
+	}
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to respond to event: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	c.AddMessageWithOptions(fmt.Sprintf("You %s:\n%s", responseNames[status], a.EventToString(event)), tgbot.MessageWithOptions{
+		ParseMode:             tgbotapi.ModeMarkdownV2,
+		DisableWebPagePreview: true,
+	})
+}
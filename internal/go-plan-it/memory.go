@@ -0,0 +1,467 @@
+package go_plan_it
+
+import (
+	"fmt"
+	"golang.org/x/oauth2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryChats is an in-memory ChatRepository, it's meant for tests and local runs.
+type MemoryChats struct {
+	mu    sync.Mutex
+	chats map[int64]Chat
+}
+
+func NewMemoryChats() *MemoryChats {
+	return &MemoryChats{chats: make(map[int64]Chat)}
+}
+
+func (m *MemoryChats) CreateChat(id int64) (*Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chats[id]; ok {
+		return nil, fmt.Errorf("CreateChat: %w", ErrChatExists)
+	}
+
+	now := time.Now()
+	chat := Chat{
+		ChatId:     id,
+		Registered: false,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	m.chats[id] = chat
+
+	return &chat, nil
+}
+
+func (m *MemoryChats) GetChatById(id int64) (*Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat, ok := m.chats[id]
+	if !ok {
+		return nil, fmt.Errorf("GetChatById: %w", ErrChatNotFound)
+	}
+
+	return &chat, nil
+}
+
+func (m *MemoryChats) DeleteChatById(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.chats, id)
+	return nil
+}
+
+func (m *MemoryChats) UpdateChat(chat *Chat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat.UpdatedAt = time.Now()
+	if chat.CreatedAt.IsZero() {
+		chat.CreatedAt = chat.UpdatedAt
+	}
+	m.chats[chat.ChatId] = *chat
+	return nil
+}
+
+func (m *MemoryChats) UpdateToken(id int64, token *oauth2.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat, ok := m.chats[id]
+	if !ok {
+		return fmt.Errorf("UpdateToken: %w", ErrChatNotFound)
+	}
+
+	chat.Token = token
+	m.chats[id] = chat
+	return nil
+}
+
+func (m *MemoryChats) UpdateSyncedAt(id int64, syncedAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat, ok := m.chats[id]
+	if !ok {
+		return fmt.Errorf("UpdateSyncedAt: %w", ErrChatNotFound)
+	}
+
+	chat.SyncedAt = &syncedAt
+	m.chats[id] = chat
+	return nil
+}
+
+func (m *MemoryChats) UpdateNextUpdate(id int64, nextUpdateAt *int64, nextEventId *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat, ok := m.chats[id]
+	if !ok {
+		return fmt.Errorf("UpdateNextUpdate: %w", ErrChatNotFound)
+	}
+
+	chat.NextUpdateAt = nextUpdateAt
+	chat.NextEventId = nextEventId
+	m.chats[id] = chat
+	return nil
+}
+
+func (m *MemoryChats) UpdateNeedsReauth(id int64, needsReauth bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat, ok := m.chats[id]
+	if !ok {
+		return fmt.Errorf("UpdateNeedsReauth: %w", ErrChatNotFound)
+	}
+
+	chat.NeedsReauth = needsReauth
+	m.chats[id] = chat
+	return nil
+}
+
+func (m *MemoryChats) UpdateChannel(id int64, channelId, channelResourceId *string, channelExpiration *int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat, ok := m.chats[id]
+	if !ok {
+		return fmt.Errorf("UpdateChannel: %w", ErrChatNotFound)
+	}
+
+	chat.ChannelId = channelId
+	chat.ChannelResourceId = channelResourceId
+	chat.ChannelExpiration = channelExpiration
+	m.chats[id] = chat
+	return nil
+}
+
+func (m *MemoryChats) GetActiveChats() ([]*Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chats := make([]*Chat, 0)
+	for _, chat := range m.chats {
+		if chat.Registered && !chat.NeedsReauth && chat.CalendarId != nil {
+			chat := chat
+			chats = append(chats, &chat)
+		}
+	}
+
+	sort.Slice(chats, func(i, j int) bool { return chats[i].ChatId < chats[j].ChatId })
+	return chats, nil
+}
+
+func (m *MemoryChats) GetChats() ([]*Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chats := make([]*Chat, 0, len(m.chats))
+	for _, chat := range m.chats {
+		chat := chat
+		chats = append(chats, &chat)
+	}
+
+	sort.Slice(chats, func(i, j int) bool { return chats[i].ChatId < chats[j].ChatId })
+	return chats, nil
+}
+
+// MemoryReminders is an in-memory ReminderRepository.
+type MemoryReminders struct {
+	mu        sync.Mutex
+	lastId    uint64
+	reminders map[uint64]Reminder
+}
+
+func NewMemoryReminders() *MemoryReminders {
+	return &MemoryReminders{reminders: make(map[uint64]Reminder)}
+}
+
+func (m *MemoryReminders) CreateReminder(reminder *Reminder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastId++
+	reminder.Id = m.lastId
+	reminder.CreatedAt = time.Now()
+	m.reminders[reminder.Id] = *reminder
+	return nil
+}
+
+func (m *MemoryReminders) GetDueReminders(now int64) ([]*Reminder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reminders := make([]*Reminder, 0)
+	for _, reminder := range m.reminders {
+		if reminder.RemindAt <= now {
+			reminder := reminder
+			reminders = append(reminders, &reminder)
+		}
+	}
+
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].RemindAt < reminders[j].RemindAt })
+	return reminders, nil
+}
+
+func (m *MemoryReminders) DeleteReminder(id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.reminders, id)
+	return nil
+}
+
+func (m *MemoryReminders) DeleteEventReminders(chatId int64, eventId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, reminder := range m.reminders {
+		if reminder.ChatId == chatId && reminder.EventId == eventId {
+			delete(m.reminders, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryReminders) DeleteChatReminders(chatId int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, reminder := range m.reminders {
+		if reminder.ChatId == chatId {
+			delete(m.reminders, id)
+		}
+	}
+	return nil
+}
+
+// MemoryContacts is an in-memory ContactRepository.
+type MemoryContacts struct {
+	mu       sync.Mutex
+	lastId   uint64
+	contacts map[uint64]Contact
+}
+
+func NewMemoryContacts() *MemoryContacts {
+	return &MemoryContacts{contacts: make(map[uint64]Contact)}
+}
+
+func (m *MemoryContacts) CreateContact(contact *Contact) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastId++
+	contact.Id = m.lastId
+	contact.CreatedAt = time.Now()
+	m.contacts[contact.Id] = *contact
+	return nil
+}
+
+func (m *MemoryContacts) UpdateContact(contact *Contact) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.contacts[contact.Id] = *contact
+	return nil
+}
+
+func (m *MemoryContacts) GetChatContacts(chatId int64) ([]*Contact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	contacts := make([]*Contact, 0)
+	for _, contact := range m.contacts {
+		if contact.ChatId == chatId {
+			contact := contact
+			contacts = append(contacts, &contact)
+		}
+	}
+
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].Name < contacts[j].Name })
+	return contacts, nil
+}
+
+func (m *MemoryContacts) DeleteContact(id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.contacts, id)
+	return nil
+}
+
+func (m *MemoryContacts) DeleteChatContacts(chatId int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, contact := range m.contacts {
+		if contact.ChatId == chatId {
+			delete(m.contacts, id)
+		}
+	}
+	return nil
+}
+
+// MemoryAttendeeResponses is an in-memory AttendeeResponseRepository.
+type MemoryAttendeeResponses struct {
+	mu        sync.Mutex
+	lastId    uint64
+	responses map[uint64]AttendeeResponse
+}
+
+func NewMemoryAttendeeResponses() *MemoryAttendeeResponses {
+	return &MemoryAttendeeResponses{responses: make(map[uint64]AttendeeResponse)}
+}
+
+func (m *MemoryAttendeeResponses) GetEventResponses(chatId int64, eventId string) ([]*AttendeeResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	responses := make([]*AttendeeResponse, 0)
+	for _, response := range m.responses {
+		if response.ChatId == chatId && response.EventId == eventId {
+			response := response
+			responses = append(responses, &response)
+		}
+	}
+	return responses, nil
+}
+
+func (m *MemoryAttendeeResponses) SaveResponse(response *AttendeeResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if response.Id == 0 {
+		m.lastId++
+		response.Id = m.lastId
+	}
+	response.UpdatedAt = time.Now()
+	m.responses[response.Id] = *response
+	return nil
+}
+
+func (m *MemoryAttendeeResponses) DeleteChatResponses(chatId int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, response := range m.responses {
+		if response.ChatId == chatId {
+			delete(m.responses, id)
+		}
+	}
+	return nil
+}
+
+// MemoryUsers is an in-memory UserRepository.
+type MemoryUsers struct {
+	mu    sync.Mutex
+	users map[int64]User
+}
+
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{users: make(map[int64]User)}
+}
+
+func (m *MemoryUsers) GetUser(userId int64) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (m *MemoryUsers) SaveUser(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := m.users[user.UserId]; ok {
+		user.CreatedAt = existing.CreatedAt
+	} else {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	m.users[user.UserId] = *user
+	return nil
+}
+
+func (m *MemoryUsers) GetUsers() ([]*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make([]*User, 0, len(m.users))
+	for _, user := range m.users {
+		user := user
+		users = append(users, &user)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].UserId < users[j].UserId })
+	return users, nil
+}
+
+// MemoryInvites is an in-memory InviteRepository, users registered with invites are saved to users.
+type MemoryInvites struct {
+	mu      sync.Mutex
+	invites map[string]Invite
+	users   *MemoryUsers
+}
+
+func NewMemoryInvites(users *MemoryUsers) *MemoryInvites {
+	return &MemoryInvites{invites: make(map[string]Invite), users: users}
+}
+
+func (m *MemoryInvites) CreateInvite(invite *Invite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invite.CreatedAt = time.Now()
+	m.invites[invite.Code] = *invite
+	return nil
+}
+
+func (m *MemoryInvites) UseInvite(code string, user *User, now int64) (*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invite, ok := m.invites[code]
+	if !ok || invite.UsedBy != nil || invite.ExpiresAt <= now {
+		return nil, ErrInviteNotFound
+	}
+
+	user.Role = invite.Role
+	user.InvitedBy = &invite.CreatedBy
+	if err := m.users.SaveUser(user); err != nil {
+		return nil, fmt.Errorf("UseInvite: %w", err)
+	}
+
+	invite.UsedBy = &user.UserId
+	m.invites[code] = invite
+	return &invite, nil
+}
+
+var (
+	_ ChatRepository     = (*Chats)(nil)
+	_ ChatRepository     = (*MemoryChats)(nil)
+	_ ReminderRepository = (*Reminders)(nil)
+	_ ReminderRepository = (*MemoryReminders)(nil)
+	_ ContactRepository  = (*Contacts)(nil)
+	_ ContactRepository  = (*MemoryContacts)(nil)
+
+	_ AttendeeResponseRepository = (*AttendeeResponses)(nil)
+	_ AttendeeResponseRepository = (*MemoryAttendeeResponses)(nil)
+
+	_ UserRepository   = (*Users)(nil)
+	_ UserRepository   = (*MemoryUsers)(nil)
+	_ InviteRepository = (*Invites)(nil)
+	_ InviteRepository = (*MemoryInvites)(nil)
+)
@@ -0,0 +1,355 @@
+package go_plan_it
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Migration is a versioned schema change. Up and Down run in a transaction
+// and must not depend on the current models, which keep changing.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type SchemaVersion struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type chatV1 struct {
+	ChatId      int64 `gorm:"primaryKey;autoIncrement:false"`
+	Registered  bool
+	NeedsReauth bool
+
+	ChannelId         *string
+	ChannelExpiration *int64
+	ChannelResourceId *string
+	CalendarId        *string
+	NextUpdateAt      *int64
+	NextEventId       *string
+	Token             *string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (chatV1) TableName() string { return "chats" }
+
+type outboundMessageV2 struct {
+	Id                    uint64 `gorm:"primaryKey"`
+	ChatId                int64  `gorm:"index"`
+	Text                  string
+	ParseMode             string
+	DisableWebPagePreview bool
+	ReplyMarkup           string
+	Attempts              int
+	CreatedAt             time.Time
+}
+
+func (outboundMessageV2) TableName() string { return "outbound_messages" }
+
+type chatV3 struct {
+	DoneStyle string
+}
+
+func (chatV3) TableName() string { return "chats" }
+
+type reminderV3 struct {
+	Id        uint64 `gorm:"primaryKey"`
+	ChatId    int64  `gorm:"index"`
+	EventId   string
+	RemindAt  int64 `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (reminderV3) TableName() string { return "reminders" }
+
+type chatV4 struct {
+	WorkStartHour *int
+	WorkEndHour   *int
+	BufferMinutes *int
+}
+
+func (chatV4) TableName() string { return "chats" }
+
+type chatV5 struct {
+	SyncedAt *int64
+}
+
+func (chatV5) TableName() string { return "chats" }
+
+type contactV5 struct {
+	Id        uint64 `gorm:"primaryKey"`
+	ChatId    int64  `gorm:"index"`
+	Name      string
+	Email     string
+	CreatedAt time.Time
+}
+
+func (contactV5) TableName() string { return "contacts" }
+
+type attendeeResponseV5 struct {
+	Id             uint64 `gorm:"primaryKey"`
+	ChatId         int64  `gorm:"index"`
+	EventId        string `gorm:"index"`
+	Email          string
+	ResponseStatus string
+	UpdatedAt      time.Time
+}
+
+func (attendeeResponseV5) TableName() string { return "attendee_responses" }
+
+type outboundMessageV6 struct {
+	ReplyToMessageId int
+}
+
+func (outboundMessageV6) TableName() string { return "outbound_messages" }
+
+type userV7 struct {
+	UserId    int64 `gorm:"primaryKey;autoIncrement:false"`
+	Role      string
+	Name      string
+	InvitedBy *int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (userV7) TableName() string { return "users" }
+
+type inviteV7 struct {
+	Code      string `gorm:"primaryKey"`
+	Role      string
+	CreatedBy int64
+	ExpiresAt int64
+	UsedBy    *int64
+	CreatedAt time.Time
+}
+
+func (inviteV7) TableName() string { return "invites" }
+
+type outboundMessageV8 struct {
+	Batch bool
+}
+
+func (outboundMessageV8) TableName() string { return "outbound_messages" }
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create chats",
+		Up: func(tx *gorm.DB) error {
+			// databases created before migrations already have the table, AutoMigrate adds missing columns
+			return tx.AutoMigrate(&chatV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&chatV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create outbound messages",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&outboundMessageV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&outboundMessageV2{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create reminders and add chat done style",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&chatV3{}, "DoneStyle"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateTable(&reminderV3{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&reminderV3{}); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&chatV3{}, "DoneStyle")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add chat working hours",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"WorkStartHour", "WorkEndHour", "BufferMinutes"} {
+				if err := tx.Migrator().AddColumn(&chatV4{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"WorkStartHour", "WorkEndHour", "BufferMinutes"} {
+				if err := tx.Migrator().DropColumn(&chatV4{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create contacts and attendee responses",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&chatV5{}, "SyncedAt"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateTable(&contactV5{}, &attendeeResponseV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&contactV5{}, &attendeeResponseV5{}); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&chatV5{}, "SyncedAt")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add outbound message reply to",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&outboundMessageV6{}, "ReplyToMessageId")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&outboundMessageV6{}, "ReplyToMessageId")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create users and invites",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&userV7{}, &inviteV7{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&userV7{}, &inviteV7{})
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add outbound message batch",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&outboundMessageV8{}, "Batch")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&outboundMessageV8{}, "Batch")
+		},
+	},
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB) *Migrator {
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}
+}
+
+func (m *Migrator) applied() (map[int]SchemaVersion, error) {
+	if err := m.db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_versions table: %w", err)
+	}
+
+	var versions []SchemaVersion
+	if err := m.db.Find(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get schema versions: %w", err)
+	}
+
+	applied := make(map[int]SchemaVersion, len(versions))
+	for _, v := range versions {
+		applied[v.Version] = v
+	}
+	return applied, nil
+}
+
+// Up applies all pending migrations and returns them.
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, fmt.Errorf("Up: %w", err)
+	}
+
+	done := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("Up: failed to apply migration %d %q: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the last steps applied migrations and returns them.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, fmt.Errorf("Down: %w", err)
+	}
+
+	done := make([]Migration, 0)
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaVersion{}, migration.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("Down: failed to roll back migration %d %q: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, fmt.Errorf("Status: %w", err)
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		v, ok := applied[migration.Version]
+		status = append(status, MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: v.AppliedAt,
+		})
+	}
+	return status, nil
+}
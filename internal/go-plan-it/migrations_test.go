@@ -0,0 +1,124 @@
+package go_plan_it
+
+import (
+	"encoding/base64"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/keyring"
+	"gorm.io/gorm"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB opens an empty SQLite database in a temporary directory.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	k, err := keyring.ParseKeys("test:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterEncryptedSerializer(k)
+
+	db, err := OpenDB(config.Database{DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = CloseDB(db) })
+	return db
+}
+
+func TestMigrations(t *testing.T) {
+	// every migration with a table or a column it creates
+	tests := []struct {
+		version int
+		table   string
+		column  string
+	}{
+		{version: 1, table: "chats", column: "token"},
+		{version: 2, table: "outbound_messages", column: "text"},
+		{version: 3, table: "chats", column: "done_style"},
+		{version: 3, table: "reminders", column: "remind_at"},
+		{version: 4, table: "chats", column: "work_start_hour"},
+		{version: 5, table: "chats", column: "synced_at"},
+		{version: 5, table: "contacts", column: "email"},
+		{version: 5, table: "attendee_responses", column: "response_status"},
+		{version: 6, table: "outbound_messages", column: "reply_to_message_id"},
+		{version: 7, table: "users", column: "role"},
+		{version: 7, table: "invites", column: "used_by"},
+		{version: 8, table: "outbound_messages", column: "batch"},
+	}
+
+	db := openTestDB(t)
+	m := NewMigrator(db)
+
+	check := func(t *testing.T, applied int) {
+		t.Helper()
+		for _, tt := range tests {
+			// a column dropped with its table is not checked, the table is
+			if tt.version > applied && db.Migrator().HasTable(tt.table) {
+				if db.Migrator().HasColumn(tt.table, tt.column) {
+					t.Errorf("%s.%s exists, migration %d is not applied", tt.table, tt.column, tt.version)
+				}
+			}
+			if tt.version <= applied && !db.Migrator().HasColumn(tt.table, tt.column) {
+				t.Errorf("%s.%s doesn't exist, migration %d is applied", tt.table, tt.column, tt.version)
+			}
+		}
+	}
+
+	done, err := m.Up()
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if len(done) != len(migrations) {
+		t.Fatalf("Up() applied %d migrations, want %d", len(done), len(migrations))
+	}
+	check(t, len(migrations))
+
+	if done, err := m.Up(); err != nil || len(done) != 0 {
+		t.Fatalf("second Up() applied %d migrations, error = %v", len(done), err)
+	}
+
+	for version := len(migrations); version > 0; version-- {
+		done, err := m.Down(1)
+		if err != nil {
+			t.Fatalf("Down() migration %d error = %v", version, err)
+		}
+		if len(done) != 1 || done[0].Version != version {
+			t.Fatalf("Down() rolled back %v, want migration %d", done, version)
+		}
+		check(t, version-1)
+	}
+
+	if db.Migrator().HasTable("chats") {
+		t.Errorf("chats table exists after rolling back all migrations")
+	}
+
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up() after rolling back error = %v", err)
+	}
+	check(t, len(migrations))
+}
+
+// TestMigrationsMatchModels catches a model field added without a migration.
+func TestMigrationsMatchModels(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	models := []any{&Chat{}, &OutboundMessage{}, &Reminder{}, &Contact{}, &AttendeeResponse{}, &User{}, &Invite{}}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("%s.%s has no migration", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package go_plan_it
+
+import (
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	"gorm.io/gorm"
+	"time"
+)
+
+type OutboundMessage struct {
+	Id                    uint64 `gorm:"primaryKey"`
+	ChatId                int64  `gorm:"index"`
+	Text                  string `gorm:"serializer:encrypted"`
+	ParseMode             string
+	DisableWebPagePreview bool
+	ReplyMarkup           string
+	ReplyToMessageId      int
+	Batch                 bool
+	Attempts              int
+	CreatedAt             time.Time
+}
+
+// Outbox is a tgbot.OutboxStore backed by gorm.
+type Outbox struct {
+	db *gorm.DB
+}
+
+func NewOutbox(db *gorm.DB) *Outbox {
+	return &Outbox{db: db}
+}
+
+func (o *Outbox) AddOutboundMessage(msg *tgbot.OutboundMessage) error {
+	m := OutboundMessage(*msg)
+	if err := o.db.Create(&m).Error; err != nil {
+		return fmt.Errorf("AddOutboundMessage: failed to create message: %w", err)
+	}
+	msg.Id = m.Id
+	return nil
+}
+
+func (o *Outbox) UpdateOutboundMessage(msg *tgbot.OutboundMessage) error {
+	if err := o.db.Model(&OutboundMessage{Id: msg.Id}).Update("attempts", msg.Attempts).Error; err != nil {
+		return fmt.Errorf("UpdateOutboundMessage: failed to update message: %w", err)
+	}
+	return nil
+}
+
+func (o *Outbox) DeleteOutboundMessages(ids ...uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := o.db.Delete(&OutboundMessage{}, ids).Error; err != nil {
+		return fmt.Errorf("DeleteOutboundMessages: failed to delete messages: %w", err)
+	}
+	return nil
+}
+
+func (o *Outbox) DeleteChatOutboundMessages(chatId int64) error {
+	if err := o.db.Where("chat_id = ?", chatId).Delete(&OutboundMessage{}).Error; err != nil {
+		return fmt.Errorf("DeleteChatOutboundMessages: failed to delete messages: %w", err)
+	}
+	return nil
+}
+
+func (o *Outbox) GetOutboundMessages() ([]*tgbot.OutboundMessage, error) {
+	var messages []OutboundMessage
+	if err := o.db.Order("id").Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("GetOutboundMessages: failed to get messages: %w", err)
+	}
+
+	result := make([]*tgbot.OutboundMessage, 0, len(messages))
+	for _, m := range messages {
+		msg := tgbot.OutboundMessage(m)
+		result = append(result, &msg)
+	}
+	return result, nil
+}
+
+var _ tgbot.OutboxStore = (*Outbox)(nil)
@@ -0,0 +1,73 @@
+package go_plan_it
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/calendar"
+	gCalendar "google.golang.org/api/calendar/v3"
+	"sync"
+	"time"
+)
+
+const proposalTTL = 30 * time.Minute
+
+// proposal is an event waiting for the user to pick one of the offered slots.
+type proposal struct {
+	chatId    int64
+	title     string
+	notes     string
+	attendees []*gCalendar.EventAttendee
+	meet      bool
+	slots     []calendar.Period
+	expiresAt time.Time
+}
+
+// proposals keeps offered slots in memory until the user taps a button or they expire.
+type proposals struct {
+	mu    sync.Mutex
+	items map[string]*proposal
+}
+
+func newProposals() *proposals {
+	return &proposals{items: make(map[string]*proposal)}
+}
+
+func (p *proposals) add(item *proposal) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("add: failed to generate proposal id: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for key, existing := range p.items {
+		if now.After(existing.expiresAt) {
+			delete(p.items, key)
+		}
+	}
+
+	item.expiresAt = now.Add(proposalTTL)
+	p.items[id] = item
+	return id, nil
+}
+
+// take removes and returns the proposal if it belongs to the chat and hasn't expired.
+func (p *proposals) take(chatId int64, id string) (*proposal, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.items[id]
+	if !ok || item.chatId != chatId {
+		return nil, false
+	}
+	delete(p.items, id)
+
+	if time.Now().After(item.expiresAt) {
+		return nil, false
+	}
+	return item, true
+}
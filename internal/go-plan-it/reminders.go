@@ -0,0 +1,298 @@
+package go_plan_it
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/golang-module/carbon"
+	"github.com/ibovyrin/go-plan-it/pkg/calendar"
+	"github.com/ibovyrin/go-plan-it/pkg/metrics"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gCalendar "google.golang.org/api/calendar/v3"
+	"gorm.io/gorm"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reminder is a snoozed event notification.
+type Reminder struct {
+	Id        uint64 `gorm:"primaryKey"`
+	ChatId    int64  `gorm:"index"`
+	EventId   string
+	RemindAt  int64 `gorm:"index"`
+	CreatedAt time.Time
+}
+
+type ReminderRepository interface {
+	CreateReminder(reminder *Reminder) error
+	// GetDueReminders returns reminders with RemindAt before or equal to now.
+	GetDueReminders(now int64) ([]*Reminder, error)
+	DeleteReminder(id uint64) error
+	DeleteEventReminders(chatId int64, eventId string) error
+	DeleteChatReminders(chatId int64) error
+}
+
+// Reminders is a ReminderRepository backed by gorm.
+type Reminders struct {
+	db *gorm.DB
+}
+
+func NewReminders(db *gorm.DB) *Reminders {
+	return &Reminders{db: db}
+}
+
+func (r *Reminders) CreateReminder(reminder *Reminder) error {
+	if err := r.db.Create(reminder).Error; err != nil {
+		return fmt.Errorf("CreateReminder: failed to create reminder: %w", err)
+	}
+	return nil
+}
+
+func (r *Reminders) GetDueReminders(now int64) ([]*Reminder, error) {
+	reminders := make([]*Reminder, 0)
+	if err := r.db.Where("remind_at <= ?", now).Order("remind_at").Find(&reminders).Error; err != nil {
+		return nil, fmt.Errorf("GetDueReminders: failed to get reminders: %w", err)
+	}
+	return reminders, nil
+}
+
+func (r *Reminders) DeleteReminder(id uint64) error {
+	if err := r.db.Delete(&Reminder{}, id).Error; err != nil {
+		return fmt.Errorf("DeleteReminder: failed to delete reminder: %w", err)
+	}
+	return nil
+}
+
+func (r *Reminders) DeleteEventReminders(chatId int64, eventId string) error {
+	if err := r.db.Where("chat_id = ? AND event_id = ?", chatId, eventId).Delete(&Reminder{}).Error; err != nil {
+		return fmt.Errorf("DeleteEventReminders: failed to delete reminders: %w", err)
+	}
+	return nil
+}
+
+func (r *Reminders) DeleteChatReminders(chatId int64) error {
+	if err := r.db.Where("chat_id = ?", chatId).Delete(&Reminder{}).Error; err != nil {
+		return fmt.Errorf("DeleteChatReminders: failed to delete reminders: %w", err)
+	}
+	return nil
+}
+
+const (
+	doneStylePrefix = "prefix"
+	doneStyleColor  = "color"
+	doneStyleNone   = "none"
+
+	donePrefix = "✅ "
+	// doneColorId is the "Basil" event color
+	doneColorId = "10"
+)
+
+var snoozeMinutes = []int{5, 10, 30}
+
+// reminderMessage creates a notification about the event with snooze, open and done buttons.
+func (a *App) reminderMessage(chatId int64, e *gCalendar.Event) *tgbotapi.MessageConfig {
+	options := tgbot.MessageWithOptions{
+		ParseMode:             tgbotapi.ModeMarkdownV2,
+		DisableWebPagePreview: true,
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, 2)
+
+	// event ids of recurring events can be too long for callback data
+	if len(tgbot.CallbackData("snooze", e.Id, "30")) <= tgbot.MaxCallbackDataLength {
+		snooze := make([]tgbotapi.InlineKeyboardButton, 0, len(snoozeMinutes))
+		for _, m := range snoozeMinutes {
+			snooze = append(snooze, tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("Snooze %d min", m),
+				tgbot.CallbackData("snooze", e.Id, strconv.Itoa(m)),
+			))
+		}
+		rows = append(rows, snooze)
+	}
+
+	actions := make([]tgbotapi.InlineKeyboardButton, 0, 3)
+	if link := calendar.JoinURL(e); link != "" {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonURL("Join", link))
+	}
+	if e.HtmlLink != "" {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonURL("Open", e.HtmlLink))
+	}
+	if len(tgbot.CallbackData("done", e.Id)) <= tgbot.MaxCallbackDataLength {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonData("Mark done", tgbot.CallbackData("done", e.Id)))
+	}
+	if len(actions) > 0 {
+		rows = append(rows, actions)
+	}
+
+	if len(rows) > 0 {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+		options.InlineKeyboard = &keyboard
+	}
+
+	return tgbot.CreateMessageWithOptions(chatId, fmt.Sprintf("You have a task:\n%s", a.EventToString(e)), options)
+}
+
+// sendDueReminders sends snoozed reminders whose time has come.
+func (a *App) sendDueReminders(c *tgbot.Context, l *slog.Logger) {
+	reminders, err := a.reminders.GetDueReminders(carbon.Now().Timestamp())
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get due reminders: %s", err))
+		return
+	}
+
+	for _, reminder := range reminders {
+		// a reminder is sent at most once, even if the event can't be fetched
+		if err := a.reminders.DeleteReminder(reminder.Id); err != nil {
+			l.Error(fmt.Sprintf("Failed to delete reminder: %s", err))
+			continue
+		}
+
+		chat, err := a.chats.GetChatById(reminder.ChatId)
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to get chat for reminder: %s", err))
+			continue
+		}
+
+		if chat.CalendarId == nil || chat.NeedsReauth {
+			continue
+		}
+
+		e, err := a.calendar.GetEventByID(c.Context(), reminder.EventId, *chat.CalendarId, a.tokenSource(chat))
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to get event by id: %s", err))
+			continue
+		}
+
+		c.AddMessageConfig(a.reminderMessage(chat.ChatId, e))
+		observeReminder("snoozed", reminder.RemindAt)
+	}
+}
+
+// observeReminder records a sent reminder and how late it is after dueAt.
+func observeReminder(kind string, dueAt int64) {
+	metrics.RemindersSent.WithLabelValues(kind).Inc()
+	if lateness := carbon.Now().Timestamp() - dueAt; lateness >= 0 {
+		metrics.ReminderLateness.WithLabelValues(kind).Observe(float64(lateness))
+	}
+}
+
+func (a *App) HandleSnoozeCallback(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "callback", "snooze")
+
+	if len(c.CallbackArgs) != 2 {
+		l.Error(fmt.Sprintf("Wrong callback args: %v", c.CallbackArgs))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	minutes, err := strconv.Atoi(c.CallbackArgs[1])
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to parse minutes: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	reminder := &Reminder{
+		ChatId:   c.ChatId,
+		EventId:  c.CallbackArgs[0],
+		RemindAt: carbon.Now().AddMinutes(minutes).Timestamp(),
+	}
+
+	if err := a.reminders.CreateReminder(reminder); err != nil {
+		l.Error(fmt.Sprintf("Failed to create reminder: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	c.AddMessage(fmt.Sprintf("Ok, I will remind you again in %d min.", minutes))
+}
+
+func (a *App) HandleDoneCallback(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "callback", "done")
+
+	if len(c.CallbackArgs) != 1 {
+		l.Error(fmt.Sprintf("Wrong callback args: %v", c.CallbackArgs))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+	eventId := c.CallbackArgs[0]
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if err := a.reminders.DeleteEventReminders(c.ChatId, eventId); err != nil {
+		l.Error(fmt.Sprintf("Failed to delete reminders: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	ctx := c.Context()
+	var patch *gCalendar.Event
+
+	// the event is changed only when the chat chose a style, doneStyleNone is the default
+	switch chat.DoneStyle {
+	case doneStyleColor:
+		patch = &gCalendar.Event{ColorId: doneColorId}
+	case doneStylePrefix:
+		e, err := a.calendar.GetEventByID(ctx, eventId, *chat.CalendarId, a.tokenSource(chat))
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to get event by id: %s", err))
+			c.AbortWithMessage(errorMessage)
+			return
+		}
+		if !strings.HasPrefix(e.Summary, donePrefix) {
+			patch = &gCalendar.Event{Summary: donePrefix + e.Summary}
+		}
+	}
+
+	if patch != nil {
+		if _, err := a.calendar.PatchEvent(ctx, *chat.CalendarId, eventId, patch, a.tokenSource(chat)); err != nil {
+			l.Error(fmt.Sprintf("Failed to patch event: %s", err))
+			c.AbortWithMessage(errorMessage)
+			return
+		}
+	}
+
+	c.AddMessage("Marked as done.")
+}
+
+func (a *App) HandleDoneStyleCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/donestyle")
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	style := strings.TrimSpace(c.Update.Message.CommandArguments())
+	if style == "" {
+		current := chat.DoneStyle
+		if current == "" {
+			current = doneStyleNone
+		}
+		c.AddMessage(fmt.Sprintf("Events marked as done are changed with: %s\n"+
+			"Use /donestyle prefix to add %s to the title, /donestyle color to make them green or /donestyle none to keep them as is.", current, donePrefix))
+		return
+	}
+
+	if style != doneStylePrefix && style != doneStyleColor && style != doneStyleNone {
+		c.AbortWithMessage("Unknown style. Use prefix, color or none.")
+		return
+	}
+
+	chat.DoneStyle = style
+	if err := a.chats.UpdateChat(chat); err != nil {
+		l.Error(fmt.Sprintf("Failed to update chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	c.AddMessage(fmt.Sprintf("Done style is set to %s.", style))
+}
@@ -0,0 +1,168 @@
+package go_plan_it
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gCalendar "google.golang.org/api/calendar/v3"
+	"gorm.io/gorm"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	responseNeedsAction = "needsAction"
+	// syncOverlap covers the clock skew between us and google when asking for updated events
+	syncOverlap = time.Minute
+	// initialSync limits how far back the first sync of a chat looks
+	initialSync = time.Hour
+)
+
+var responseNames = map[string]string{
+	"accepted":  "accepted",
+	"tentative": "tentatively accepted",
+	"declined":  "declined",
+}
+
+// AttendeeResponse is the last known response of an attendee to an event organized by the chat.
+type AttendeeResponse struct {
+	Id             uint64 `gorm:"primaryKey"`
+	ChatId         int64  `gorm:"index"`
+	EventId        string `gorm:"index"`
+	Email          string `gorm:"serializer:encrypted"`
+	ResponseStatus string
+	UpdatedAt      time.Time
+}
+
+type AttendeeResponseRepository interface {
+	GetEventResponses(chatId int64, eventId string) ([]*AttendeeResponse, error)
+	// SaveResponse creates the response or updates it if it has an id.
+	SaveResponse(response *AttendeeResponse) error
+	DeleteChatResponses(chatId int64) error
+}
+
+// AttendeeResponses is an AttendeeResponseRepository backed by gorm.
+type AttendeeResponses struct {
+	db *gorm.DB
+}
+
+func NewAttendeeResponses(db *gorm.DB) *AttendeeResponses {
+	return &AttendeeResponses{db: db}
+}
+
+func (r *AttendeeResponses) GetEventResponses(chatId int64, eventId string) ([]*AttendeeResponse, error) {
+	responses := make([]*AttendeeResponse, 0)
+	if err := r.db.Where("chat_id = ? AND event_id = ?", chatId, eventId).Find(&responses).Error; err != nil {
+		return nil, fmt.Errorf("GetEventResponses: failed to get responses: %w", err)
+	}
+	return responses, nil
+}
+
+func (r *AttendeeResponses) SaveResponse(response *AttendeeResponse) error {
+	if err := r.db.Save(response).Error; err != nil {
+		return fmt.Errorf("SaveResponse: failed to save response: %w", err)
+	}
+	return nil
+}
+
+func (r *AttendeeResponses) DeleteChatResponses(chatId int64) error {
+	if err := r.db.Where("chat_id = ?", chatId).Delete(&AttendeeResponse{}).Error; err != nil {
+		return fmt.Errorf("DeleteChatResponses: failed to delete responses: %w", err)
+	}
+	return nil
+}
+
+// syncChanges looks through events changed since the last sync of the chat and
+// reports what the user should know about.
+func (a *App) syncChanges(ctx context.Context, chat *Chat, l *slog.Logger) error {
+	now := time.Now()
+	since := now.Add(-initialSync)
+	if chat.SyncedAt != nil {
+		since = time.Unix(*chat.SyncedAt, 0).Add(-syncOverlap)
+	}
+
+	events, err := a.calendar.GetUpdatedEvents(ctx, *chat.CalendarId, since.Format(time.RFC3339), a.tokenSource(chat))
+	if err != nil {
+		return fmt.Errorf("syncChanges: %w", err)
+	}
+
+	messages := make([]*tgbotapi.MessageConfig, 0)
+	for _, event := range events {
+		if event.Organizer != nil && event.Organizer.Self && len(event.Attendees) > 0 {
+			changes, err := a.responseChanges(chat, event)
+			if err != nil {
+				l.Error(fmt.Sprintf("Failed to check responses of event %s: %s", event.Id, err))
+				continue
+			}
+			if len(changes) > 0 {
+				messages = append(messages, tgbot.CreateMessageWithOptions(chat.ChatId,
+					fmt.Sprintf("%s\n%s", a.EventToString(event), escapeMarkdown(strings.Join(changes, "\n"))),
+					tgbot.MessageWithOptions{ParseMode: tgbotapi.ModeMarkdownV2, DisableWebPagePreview: true}))
+			}
+			continue
+		}
+
+		invited, err := a.newInvitation(chat, event)
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to check invitation %s: %s", event.Id, err))
+			continue
+		}
+		if invited {
+			messages = append(messages, a.invitationMessage(chat.ChatId, event))
+		}
+	}
+	a.bot.SendMessages(messages)
+
+	synced := now.Unix()
+	chat.SyncedAt = &synced
+	if err := a.chats.UpdateSyncedAt(chat.ChatId, synced); err != nil {
+		return fmt.Errorf("syncChanges: %w", err)
+	}
+	return nil
+}
+
+// responseChanges stores the responses of the event attendees and describes the changed ones.
+func (a *App) responseChanges(chat *Chat, event *gCalendar.Event) ([]string, error) {
+	stored, err := a.responses.GetEventResponses(chat.ChatId, event.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]*AttendeeResponse, len(stored))
+	for _, response := range stored {
+		known[strings.ToLower(response.Email)] = response
+	}
+
+	changes := make([]string, 0)
+	for _, attendee := range event.Attendees {
+		if attendee.Self || attendee.Resource {
+			continue
+		}
+
+		email := strings.ToLower(attendee.Email)
+		response, ok := known[email]
+		if !ok {
+			response = &AttendeeResponse{ChatId: chat.ChatId, EventId: event.Id, Email: email, ResponseStatus: responseNeedsAction}
+		}
+		if response.ResponseStatus == attendee.ResponseStatus {
+			continue
+		}
+
+		if name, ok := responseNames[attendee.ResponseStatus]; ok {
+			who := attendee.DisplayName
+			if who == "" {
+				who = attendee.Email
+			}
+			changes = append(changes, fmt.Sprintf("%s %s", who, name))
+		}
+
+		response.ResponseStatus = attendee.ResponseStatus
+		if err := a.responses.SaveResponse(response); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,85 @@
+package go_plan_it
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/keyring"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+const encryptedSerializer = "encrypted"
+
+// EncryptedSerializer stores a field as JSON encrypted with the keyring.
+// The column name is used as additional data, so a value can't be moved to another column.
+type EncryptedSerializer struct {
+	keyring *keyring.Keyring
+}
+
+func RegisterEncryptedSerializer(k *keyring.Keyring) {
+	schema.RegisterSerializer(encryptedSerializer, EncryptedSerializer{keyring: k})
+}
+
+func (s EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType)
+
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("EncryptedSerializer: unsupported value type %T", dbValue)
+	}
+
+	if value != "" {
+		data, err := s.keyring.Decrypt(value, []byte(field.DBName))
+		switch {
+		case errors.Is(err, keyring.ErrNotEncrypted):
+			// the value was written before encryption was enabled
+			data = []byte(value)
+		case err != nil:
+			return fmt.Errorf("EncryptedSerializer: failed to decrypt %s: %w", field.DBName, err)
+		}
+
+		if err := json.Unmarshal(data, fieldValue.Interface()); err != nil {
+			if !setPlainString(fieldValue, value) {
+				return fmt.Errorf("EncryptedSerializer: failed to unmarshal %s: %w", field.DBName, err)
+			}
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (s EncryptedSerializer) Value(_ context.Context, field *schema.Field, _ reflect.Value, fieldValue interface{}) (interface{}, error) {
+	data, err := json.Marshal(fieldValue)
+	if err != nil {
+		return nil, fmt.Errorf("EncryptedSerializer: failed to marshal %s: %w", field.DBName, err)
+	}
+
+	if string(data) == "null" {
+		return nil, nil
+	}
+
+	return s.keyring.Encrypt(data, []byte(field.DBName))
+}
+
+// setPlainString handles legacy plain text columns like calendar_id.
+func setPlainString(v reflect.Value, s string) bool {
+	e := v.Elem()
+	switch {
+	case e.Kind() == reflect.String:
+		e.SetString(s)
+		return true
+	case e.Kind() == reflect.Pointer && e.Type().Elem().Kind() == reflect.String:
+		e.Set(reflect.ValueOf(&s))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package go_plan_it
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/golang-module/carbon"
+	"github.com/ibovyrin/go-plan-it/pkg/calendar"
+	"github.com/ibovyrin/go-plan-it/pkg/gpt"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gCalendar "google.golang.org/api/calendar/v3"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxSlotOptions  = 3
+	defaultDuration = 30
+	slotLayout      = "Mon 2 Jan 15:04"
+)
+
+// calendarIds returns the calendars whose events make the chat busy. Groups only
+// use the linked calendar, the primary one belongs to the admin who linked it.
+func calendarIds(chat *Chat) []string {
+	ids := make([]string, 0, 2)
+	if !chat.isGroup() {
+		ids = append(ids, "primary")
+	}
+	if chat.CalendarId != nil && *chat.CalendarId != "primary" {
+		ids = append(ids, *chat.CalendarId)
+	}
+	return ids
+}
+
+func slotOptions(chat *Chat, duration time.Duration) calendar.SlotOptions {
+	start, end, buffer := chat.workHours()
+	return calendar.SlotOptions{
+		Duration:  duration,
+		Buffer:    time.Duration(buffer) * time.Minute,
+		WorkStart: time.Duration(start) * time.Hour,
+		WorkEnd:   time.Duration(end) * time.Hour,
+		Limit:     maxSlotOptions,
+	}
+}
+
+// findSlots returns the earliest free slots of the chat calendars in the window.
+func (a *App) findSlots(ctx context.Context, chat *Chat, window calendar.Period, duration time.Duration) ([]calendar.Period, error) {
+	busy, err := a.calendar.FreeBusy(ctx, calendarIds(chat), window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339), a.tokenSource(chat))
+	if err != nil {
+		return nil, fmt.Errorf("findSlots: %w", err)
+	}
+
+	return calendar.FindSlots(window, busy, slotOptions(chat, duration)), nil
+}
+
+func slotLabel(slot calendar.Period) string {
+	return fmt.Sprintf("%s–%s", slot.Start.In(time.Local).Format(slotLayout), slot.End.In(time.Local).Format(timeLayout))
+}
+
+// proposeSlots offers slots as buttons, tapping one creates the event. Buttons are
+// labeled with the slot times unless labels are given.
+func (a *App) proposeSlots(c *tgbot.Context, l *slog.Logger, text string, p *proposal, labels []string) {
+	id, err := a.proposals.add(p)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to add proposal: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(p.slots))
+	for i, slot := range p.slots {
+		label := slotLabel(slot)
+		if i < len(labels) {
+			label = labels[i]
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, tgbot.CallbackData("slot", id, strconv.Itoa(i))),
+		))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	c.AddMessageWithOptions(text, tgbot.MessageWithOptions{InlineKeyboard: &keyboard})
+}
+
+func (a *App) HandleSlotCommand(c *tgbot.Context) {
+	if c.Update.Message.CommandArguments() == "" {
+		c.AddMessage("What should I find time for? For example: 45 minutes tomorrow afternoon for a call.")
+		c.RegisterWaitForInput()
+		return
+	}
+
+	a.findSlot(c, c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/slot"), c.Update.Message.CommandArguments())
+}
+
+func (a *App) HandleSlotCommandResponse(c *tgbot.Context) {
+	a.findSlot(c, c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/slot_response"), c.Update.Message.Text)
+}
+
+func (a *App) findSlot(c *tgbot.Context, l *slog.Logger, text string) {
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if strings.TrimSpace(text) == "" {
+		c.AbortWithMessage("You need to describe what to find time for. Please start again /slot.")
+		return
+	}
+
+	resp, err := a.gpt.ParseSlotRequest(c.Context(), &gpt.Request{
+		Description: text,
+		Today:       carbon.Now().String(),
+	})
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to parse request with gpt: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	duration := resp.Duration
+	if duration <= 0 {
+		duration = defaultDuration
+	}
+
+	now := time.Now()
+	window := calendar.Period{Start: now, End: now.AddDate(0, 0, 7)}
+	from, to := carbon.Parse(resp.From), carbon.Parse(resp.To)
+	if from.Error == nil && to.Error == nil && to.ToStdTime().After(from.ToStdTime()) {
+		window = calendar.Period{Start: latest(from.ToStdTime(), now), End: to.ToStdTime()}
+	}
+
+	slots, err := a.findSlots(c.Context(), chat, window, time.Duration(duration)*time.Minute)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to find slots: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if len(slots) == 0 {
+		c.AbortWithMessage("I couldn't find free time in your working hours for that period. Try a wider period or change /workhours.")
+		return
+	}
+
+	a.proposeSlots(c, l, fmt.Sprintf("%s, %d min. Pick a time:", resp.Title, duration), &proposal{
+		chatId: c.ChatId,
+		title:  resp.Title,
+		notes:  resp.Notes,
+		slots:  slots,
+	}, nil)
+}
+
+func (a *App) HandleSlotCallback(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "callback", "slot")
+
+	if len(c.CallbackArgs) != 2 {
+		l.Error(fmt.Sprintf("Wrong callback args: %v", c.CallbackArgs))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	index, err := strconv.Atoi(c.CallbackArgs[1])
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to parse slot index: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	p, ok := a.proposals.take(c.ChatId, c.CallbackArgs[0])
+	if !ok || index < 0 || index >= len(p.slots) {
+		c.AbortWithMessage("This proposal has expired. Please start again.")
+		return
+	}
+	slot := p.slots[index]
+
+	e := &gCalendar.Event{
+		Description: p.notes,
+		Start:       &gCalendar.EventDateTime{DateTime: slot.Start.Format(time.RFC3339)},
+		End:         &gCalendar.EventDateTime{DateTime: slot.End.Format(time.RFC3339)},
+		Summary:     p.title,
+		Attendees:   p.attendees,
+	}
+	if p.meet {
+		e.ConferenceData = calendar.NewMeetRequest()
+	}
+
+	a.createEvent(c, l, chat, e)
+}
+
+// createEvent inserts the event into the chat calendar and reports it to the user.
+func (a *App) createEvent(c *tgbot.Context, l *slog.Logger, chat *Chat, e *gCalendar.Event) {
+	ctx := c.Context()
+	if err := a.calendar.CreateEvent(ctx, *chat.CalendarId, e, a.tokenSource(chat)); err != nil {
+		l.Error(fmt.Sprintf("Failed create a new event: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	text := fmt.Sprintf("I created an event:\n%s", a.EventToString(e))
+	if link := calendar.JoinURL(e); link != "" {
+		text += fmt.Sprintf("\n[Join the video call](%s)", link)
+	}
+
+	c.AddMessageWithOptions(text, tgbot.MessageWithOptions{
+		ParseMode:             tgbotapi.ModeMarkdownV2,
+		DisableWebPagePreview: true,
+	})
+
+	if err := a.setNextUpdateTimeForChat(ctx, chat); err != nil {
+		l.Error(fmt.Sprintf("Failed setNextUpdateTimeForChat: %s", err))
+	}
+}
+
+func (a *App) HandleWorkHoursCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/workhours")
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	args := strings.Fields(c.Update.Message.CommandArguments())
+	if len(args) == 0 {
+		start, end, buffer := chat.workHours()
+		c.AddMessage(fmt.Sprintf("Your working hours are %d-%d with %d min between events.\n"+
+			"Use /workhours 9-18 15 to change the hours and the buffer in minutes.", start, end, buffer))
+		return
+	}
+
+	start, end, ok := parseHours(args[0])
+	if !ok || len(args) > 2 {
+		c.AbortWithMessage("Wrong format. Use /workhours 9-18 or /workhours 9-18 15.")
+		return
+	}
+	chat.WorkStartHour, chat.WorkEndHour = &start, &end
+
+	if len(args) == 2 {
+		buffer, err := strconv.Atoi(args[1])
+		if err != nil || buffer < 0 || buffer > 120 {
+			c.AbortWithMessage("The buffer must be a number of minutes between 0 and 120.")
+			return
+		}
+		chat.BufferMinutes = &buffer
+	}
+
+	if err := a.chats.UpdateChat(chat); err != nil {
+		l.Error(fmt.Sprintf("Failed to update chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	start, end, buffer := chat.workHours()
+	c.AddMessage(fmt.Sprintf("Working hours are set to %d-%d with %d min between events.", start, end, buffer))
+}
+
+// parseHours parses working hours like "9-18".
+func parseHours(s string) (int, int, bool) {
+	from, to, found := strings.Cut(s, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.Atoi(from)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if start < 0 || end > 24 || start >= end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
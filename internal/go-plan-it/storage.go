@@ -0,0 +1,36 @@
+package go_plan_it
+
+import "gorm.io/gorm"
+
+// Storage groups the repositories used by the App.
+type Storage struct {
+	Chats     ChatRepository
+	Reminders ReminderRepository
+	Contacts  ContactRepository
+	Responses AttendeeResponseRepository
+	Users     UserRepository
+	Invites   InviteRepository
+}
+
+func NewStorage(db *gorm.DB) *Storage {
+	return &Storage{
+		Chats:     NewChats(db),
+		Reminders: NewReminders(db),
+		Contacts:  NewContacts(db),
+		Responses: NewAttendeeResponses(db),
+		Users:     NewUsers(db),
+		Invites:   NewInvites(db),
+	}
+}
+
+func NewMemoryStorage() *Storage {
+	users := NewMemoryUsers()
+	return &Storage{
+		Chats:     NewMemoryChats(),
+		Reminders: NewMemoryReminders(),
+		Contacts:  NewMemoryContacts(),
+		Responses: NewMemoryAttendeeResponses(),
+		Users:     users,
+		Invites:   NewMemoryInvites(users),
+	}
+}
@@ -0,0 +1,179 @@
+package go_plan_it
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/golang-module/carbon"
+	"github.com/ibovyrin/go-plan-it/pkg/gpt"
+	"github.com/ibovyrin/go-plan-it/pkg/tasks"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	gTasks "google.golang.org/api/tasks/v1"
+	"log/slog"
+	"strings"
+)
+
+const (
+	tasksScopeMessage = "I need access to Google Tasks for to-dos. Please log in again to allow it."
+	maxTaskButtons    = 20
+)
+
+// handleTasksError aborts with a login link when the token was issued without the tasks scope.
+// The chat is not marked as needing a new login, the token still works for the calendar.
+func (a *App) handleTasksError(c *tgbot.Context, l *slog.Logger, chat *Chat, err error) {
+	if errors.Is(err, tasks.ErrInsufficientScope) {
+		l.Warn(fmt.Sprintf("Token has no tasks scope: %s", err))
+		c.AddMessageConfig(a.loginMessage(chat.ChatId, tasksScopeMessage))
+		c.Abort()
+		return
+	}
+
+	l.Error(fmt.Sprintf("Failed to call google tasks: %s", err))
+	c.AbortWithMessage(errorMessage)
+}
+
+// createTodo adds a to-do parsed by gpt to the default task list.
+func (a *App) createTodo(c *tgbot.Context, l *slog.Logger, chat *Chat, resp gpt.Response) {
+	task := &gTasks.Task{
+		Title: resp.Title,
+		Notes: resp.Notes,
+	}
+
+	if resp.Date != "" {
+		if due := carbon.Parse(resp.Date); due.Error == nil {
+			// google tasks keeps only the date part of the due time
+			task.Due = due.ToDateString() + "T00:00:00.000Z"
+		}
+	}
+
+	created, err := a.tasks.CreateTask(c.Context(), task, a.tokenSource(chat))
+	if err != nil {
+		a.handleTasksError(c, l, chat, err)
+		return
+	}
+
+	c.AddMessageWithOptions(fmt.Sprintf("I added a to-do:\n%s", formatTaskLine(created)), tgbot.MessageWithOptions{
+		ParseMode: tgbotapi.ModeMarkdownV2,
+	})
+}
+
+func (a *App) HandleTodoCommand(c *tgbot.Context) {
+	if c.Update.Message.CommandArguments() == "" {
+		c.AddMessage("What do you need to do?")
+		c.RegisterWaitForInput()
+		return
+	}
+
+	a.parseTodo(c, c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/todo"), c.Update.Message.CommandArguments())
+}
+
+func (a *App) HandleTodoCommandResponse(c *tgbot.Context) {
+	a.parseTodo(c, c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/todo_response"), c.Update.Message.Text)
+}
+
+func (a *App) parseTodo(c *tgbot.Context, l *slog.Logger, text string) {
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if strings.TrimSpace(text) == "" {
+		c.AbortWithMessage("You need to describe the to-do. Please start again /todo.")
+		return
+	}
+
+	resp, err := a.gpt.ParseRequest(c.Context(), &gpt.Request{
+		Description: text,
+		Today:       carbon.Now().String(),
+	})
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to parse request with gpt: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	a.createTodo(c, l, chat, resp)
+}
+
+func (a *App) HandleTasksCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/tasks")
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	todos, err := a.tasks.GetTasksList(c.Context(), "", a.tokenSource(chat))
+	if err != nil {
+		a.handleTasksError(c, l, chat, err)
+		return
+	}
+
+	if len(todos) == 0 {
+		c.AbortWithMessage("You have no open to-dos. Use /todo to add one.")
+		return
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, maxTaskButtons)
+	for _, todo := range todos {
+		if len(rows) == maxTaskButtons {
+			break
+		}
+		if len(tgbot.CallbackData("task", todo.Id)) > tgbot.MaxCallbackDataLength {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✔ "+todo.Title, tgbot.CallbackData("task", todo.Id)),
+		))
+	}
+
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	if len(rows) > 0 {
+		markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+		keyboard = &markup
+	}
+
+	for _, msg := range agendaMessages(c.ChatId, formatAgenda("Your to-dos, tap one to complete it:", nil, todos), keyboard) {
+		c.AddMessageConfig(msg)
+	}
+}
+
+func (a *App) HandleTaskCallback(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "callback", "task")
+
+	if len(c.CallbackArgs) != 1 {
+		l.Error(fmt.Sprintf("Wrong callback args: %v", c.CallbackArgs))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	chat, err := a.chats.GetChatById(c.ChatId)
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get chat: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	task, err := a.tasks.CompleteTask(c.Context(), c.CallbackArgs[0], a.tokenSource(chat))
+	if err != nil {
+		a.handleTasksError(c, l, chat, err)
+		return
+	}
+
+	c.AddMessage(fmt.Sprintf("Completed: %s", task.Title))
+}
+
+// dueTodos returns open tasks due today or earlier, tasks errors don't break the agenda.
+func (a *App) dueTodos(ctx context.Context, l *slog.Logger, chat *Chat) []*gTasks.Task {
+	todos, err := a.tasks.GetTasksList(ctx, carbon.Now().EndOfDay().ToRfc3339String(), a.tokenSource(chat))
+	if err != nil {
+		l.Warn(fmt.Sprintf("Failed to get tasks for chat %d: %s", chat.ChatId, err))
+		return nil
+	}
+	return todos
+}
@@ -0,0 +1,58 @@
+package go_plan_it
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	"golang.org/x/oauth2"
+	"strconv"
+)
+
+const reauthMessage = "Your Google authorization has expired or was revoked. Use /start to log in again."
+
+// chatTokenHandler persists refreshed tokens of a chat and asks the user
+// to log in again when Google rejects the refresh token.
+type chatTokenHandler struct {
+	app  *App
+	chat *Chat
+}
+
+func (a *App) tokenSource(chat *Chat) oauth2.TokenSource {
+	return a.calendar.TokenSource(chat.Token, &chatTokenHandler{app: a, chat: chat})
+}
+
+func (h *chatTokenHandler) TokenRefreshed(token *oauth2.Token) error {
+	h.chat.Token = token
+	if err := h.app.chats.UpdateToken(h.chat.ChatId, token); err != nil {
+		return fmt.Errorf("TokenRefreshed: %w", err)
+	}
+	return nil
+}
+
+func (h *chatTokenHandler) TokenRevoked(err error) {
+	h.app.logger.With("chat_id", h.chat.ChatId).Warn(fmt.Sprintf("Token is revoked: %s", err))
+	h.app.requestReauth(h.chat, reauthMessage)
+}
+
+// requestReauth marks the chat as needing a new login and sends the login link.
+func (a *App) requestReauth(chat *Chat, message string) {
+	l := a.logger.With("chat_id", chat.ChatId, "token", "requestReauth")
+
+	if chat.NeedsReauth {
+		return
+	}
+
+	chat.NeedsReauth = true
+	if err := a.chats.UpdateNeedsReauth(chat.ChatId, chat.NeedsReauth); err != nil {
+		l.Error(fmt.Sprintf("Failed to update chat: %s", err))
+		return
+	}
+
+	a.bot.SendMessages([]*tgbotapi.MessageConfig{a.loginMessage(chat.ChatId, message)})
+}
+
+// loginMessage returns message followed by the login link of the chat.
+func (a *App) loginMessage(chatId int64, message string) *tgbotapi.MessageConfig {
+	authCodeURL := a.calendar.GetAuthURL(strconv.FormatInt(chatId, 10))
+	return tgbot.CreateMessage(chatId, fmt.Sprintf("%s\n%s", message, authCodeURL))
+}
@@ -0,0 +1,351 @@
+package go_plan_it
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/golang-module/carbon"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	"gorm.io/gorm"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleUser    = "user"
+	RoleBlocked = "blocked"
+
+	inviteTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrInviteNotFound = errors.New("invite not found or already used")
+)
+
+// User is a telegram user allowed to talk to the bot, keyed by the numeric telegram id
+// because usernames can change or be missing.
+type User struct {
+	UserId    int64 `gorm:"primaryKey;autoIncrement:false"`
+	Role      string
+	Name      string
+	InvitedBy *int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Invite is a one-time code which registers a new user with its role.
+type Invite struct {
+	Code      string `gorm:"primaryKey"`
+	Role      string
+	CreatedBy int64
+	ExpiresAt int64
+	UsedBy    *int64
+	CreatedAt time.Time
+}
+
+type UserRepository interface {
+	GetUser(userId int64) (*User, error)
+	// SaveUser creates the user or updates an existing one.
+	SaveUser(user *User) error
+	GetUsers() ([]*User, error)
+}
+
+type InviteRepository interface {
+	CreateInvite(invite *Invite) error
+	// UseInvite marks an unused and not expired invite as used by the user and saves the user with the role
+	// of the invite. Both are saved or none, so a failed save doesn't use up the invite.
+	UseInvite(code string, user *User, now int64) (*Invite, error)
+}
+
+// Users is a UserRepository backed by gorm.
+type Users struct {
+	db *gorm.DB
+}
+
+func NewUsers(db *gorm.DB) *Users {
+	return &Users{db: db}
+}
+
+func (r *Users) GetUser(userId int64) (*User, error) {
+	var user User
+	err := r.db.First(&user, userId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetUser: failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+func (r *Users) SaveUser(user *User) error {
+	if err := r.db.Save(user).Error; err != nil {
+		return fmt.Errorf("SaveUser: failed to save user: %w", err)
+	}
+	return nil
+}
+
+func (r *Users) GetUsers() ([]*User, error) {
+	users := make([]*User, 0)
+	if err := r.db.Order("user_id").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("GetUsers: failed to get users: %w", err)
+	}
+	return users, nil
+}
+
+// Invites is an InviteRepository backed by gorm.
+type Invites struct {
+	db *gorm.DB
+}
+
+func NewInvites(db *gorm.DB) *Invites {
+	return &Invites{db: db}
+}
+
+func (r *Invites) CreateInvite(invite *Invite) error {
+	if err := r.db.Create(invite).Error; err != nil {
+		return fmt.Errorf("CreateInvite: failed to create invite: %w", err)
+	}
+	return nil
+}
+
+func (r *Invites) UseInvite(code string, user *User, now int64) (*Invite, error) {
+	var invite Invite
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// the condition makes two users racing for the same code update it only once
+		result := tx.Model(&Invite{}).
+			Where("code = ? AND used_by IS NULL AND expires_at > ?", code, now).
+			Update("used_by", user.UserId)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInviteNotFound
+		}
+		if err := tx.First(&invite, "code = ?", code).Error; err != nil {
+			return err
+		}
+
+		user.Role = invite.Role
+		user.InvitedBy = &invite.CreatedBy
+		return tx.Save(user).Error
+	})
+	if errors.Is(err, ErrInviteNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("UseInvite: failed to use invite: %w", err)
+	}
+	return &invite, nil
+}
+
+// seedAdmins gives the admin role to the configured users, so the bot can be managed from the start.
+func (a *App) seedAdmins(admins []int64) error {
+	for _, userId := range admins {
+		user, err := a.users.GetUser(userId)
+		if errors.Is(err, ErrUserNotFound) {
+			user = &User{UserId: userId}
+		} else if err != nil {
+			return fmt.Errorf("seedAdmins: %w", err)
+		}
+
+		if user.Role == RoleAdmin {
+			continue
+		}
+		user.Role = RoleAdmin
+		if err := a.users.SaveUser(user); err != nil {
+			return fmt.Errorf("seedAdmins: %w", err)
+		}
+	}
+	return nil
+}
+
+func senderName(c *tgbot.Context) string {
+	user := c.Update.SentFrom()
+	if user == nil {
+		return ""
+	}
+	if user.UserName != "" {
+		return "@" + user.UserName
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+func (a *App) refuse(c *tgbot.Context) {
+	// groups are not flooded with refusals for every member
+	if c.IsGroup() {
+		c.Abort()
+		return
+	}
+	c.AbortWithMessage("Sorry, I can't talk to you. Ask an admin of this bot for an invite link.")
+}
+
+// IsAllowed lets registered users through and registers new ones with an invite
+// code from /start or, with open registration, without it. It runs before every handler.
+func (a *App) IsAllowed(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "user_id", c.UserId, "middleware", "IsAllowed")
+
+	user, err := a.users.GetUser(c.UserId)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		a.registerUser(c, l)
+	case err != nil:
+		l.Error(fmt.Sprintf("Failed to get user: %s", err))
+		c.AbortWithMessage(errorMessage)
+	case user.Role == RoleBlocked:
+		a.refuse(c)
+	}
+}
+
+func (a *App) registerUser(c *tgbot.Context, l *slog.Logger) {
+	user := &User{UserId: c.UserId, Role: RoleUser, Name: senderName(c)}
+
+	if a.access.Registration != config.RegistrationOpen {
+		code := ""
+		if c.Command == "start" && c.Update.Message != nil {
+			code = strings.TrimSpace(c.Update.Message.CommandArguments())
+		}
+		if code == "" {
+			a.refuse(c)
+			return
+		}
+
+		_, err := a.invites.UseInvite(code, user, carbon.Now().Timestamp())
+		if errors.Is(err, ErrInviteNotFound) {
+			c.AbortWithMessage("This invite link is not valid anymore. Ask an admin of this bot for a new one.")
+			return
+		}
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to use invite: %s", err))
+			c.AbortWithMessage(errorMessage)
+		}
+		return
+	}
+
+	if err := a.users.SaveUser(user); err != nil {
+		l.Error(fmt.Sprintf("Failed to save user: %s", err))
+		c.AbortWithMessage(errorMessage)
+	}
+}
+
+// IsAdmin lets only bot admins through.
+func (a *App) IsAdmin(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "user_id", c.UserId, "middleware", "IsAdmin")
+
+	user, err := a.users.GetUser(c.UserId)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		l.Error(fmt.Sprintf("Failed to get user: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	if user == nil || user.Role != RoleAdmin {
+		c.AbortWithMessage("Only admins of this bot can do this.")
+	}
+}
+
+func (a *App) HandleInviteCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/invite")
+
+	role := strings.TrimSpace(c.Update.Message.CommandArguments())
+	if role == "" {
+		role = RoleUser
+	}
+	if role != RoleUser && role != RoleAdmin {
+		c.AbortWithMessage("Invites can be for the user or the admin role.")
+		return
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		l.Error(fmt.Sprintf("Failed to generate invite code: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	invite := &Invite{
+		Code:      base64.RawURLEncoding.EncodeToString(b),
+		Role:      role,
+		CreatedBy: c.UserId,
+		ExpiresAt: time.Now().Add(inviteTTL).Unix(),
+	}
+	if err := a.invites.CreateInvite(invite); err != nil {
+		l.Error(fmt.Sprintf("Failed to create invite: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	c.AddMessage(fmt.Sprintf("Send this link to the new %s, it works once during the next 7 days:\nhttps://t.me/%s?start=%s",
+		role, a.bot.Username(), invite.Code))
+}
+
+func (a *App) HandleUsersCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/users")
+
+	users, err := a.users.GetUsers()
+	if err != nil {
+		l.Error(fmt.Sprintf("Failed to get users: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	lines := make([]string, 0, len(users))
+	for _, user := range users {
+		name := user.Name
+		if name == "" {
+			name = "(unknown)"
+		}
+		lines = append(lines, fmt.Sprintf("%d %s - %s", user.UserId, name, user.Role))
+	}
+
+	for _, text := range splitLines(fmt.Sprintf("Users:\n%s\n\nUse /role <user id> <admin|user|blocked> to change a role.", strings.Join(lines, "\n"))) {
+		c.AddMessage(text)
+	}
+}
+
+func (a *App) HandleRoleCommand(c *tgbot.Context) {
+	l := c.Logger(a.logger).With("chat_id", c.ChatId, "command", "/role")
+
+	args := strings.Fields(c.Update.Message.CommandArguments())
+	if len(args) != 2 {
+		c.AbortWithMessage("Wrong format. Use /role <user id> <admin|user|blocked>.")
+		return
+	}
+
+	userId, err := strconv.ParseInt(args[0], 10, 64)
+	role := args[1]
+	if err != nil || (role != RoleAdmin && role != RoleUser && role != RoleBlocked) {
+		c.AbortWithMessage("Wrong format. Use /role <user id> <admin|user|blocked>.")
+		return
+	}
+
+	if userId == c.UserId {
+		c.AbortWithMessage("You can't change your own role.")
+		return
+	}
+
+	user, err := a.users.GetUser(userId)
+	if errors.Is(err, ErrUserNotFound) {
+		// users can be added before they talk to the bot
+		user = &User{UserId: userId}
+	} else if err != nil {
+		l.Error(fmt.Sprintf("Failed to get user: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	user.Role = role
+	if err := a.users.SaveUser(user); err != nil {
+		l.Error(fmt.Sprintf("Failed to save user: %s", err))
+		c.AbortWithMessage(errorMessage)
+		return
+	}
+
+	c.AddMessage(fmt.Sprintf("User %d is now %s.", userId, role))
+}
@@ -0,0 +1,168 @@
+package go_plan_it
+
+import (
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/golang-module/carbon"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/tgbot"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const (
+	adminId   = 1
+	blockedId = 2
+	newUserId = 3
+)
+
+// newTestApp returns an App with memory repositories, an admin, a blocked user and invites:
+// "valid" with the admin role, "used" and "expired".
+func newTestApp(t *testing.T, registration string) *App {
+	t.Helper()
+
+	storage := NewMemoryStorage()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a, err := NewApp(config.Access{Admins: []int64{adminId}, Registration: registration}, storage, nil, nil, nil, logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Users.SaveUser(&User{UserId: blockedId, Role: RoleBlocked}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := carbon.Now().Timestamp()
+	for _, invite := range []*Invite{
+		{Code: "valid", Role: RoleAdmin, CreatedBy: adminId, ExpiresAt: now + 3600},
+		{Code: "used", Role: RoleUser, CreatedBy: adminId, ExpiresAt: now + 3600},
+		{Code: "expired", Role: RoleUser, CreatedBy: adminId, ExpiresAt: now - 1},
+	} {
+		if err := storage.Invites.CreateInvite(invite); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := storage.Invites.UseInvite("used", &User{UserId: 100}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+// newTestContext returns the context of a message sent by the user in a private chat or a group.
+func newTestContext(userId int64, text string, group bool) *tgbot.Context {
+	chat := &tgbotapi.Chat{ID: userId, Type: "private"}
+	if group {
+		chat = &tgbotapi.Chat{ID: -10, Type: "group"}
+	}
+
+	message := &tgbotapi.Message{
+		From: &tgbotapi.User{ID: userId, UserName: "user"},
+		Chat: chat,
+		Text: text,
+	}
+	command := ""
+	if strings.HasPrefix(text, "/") {
+		length := len(strings.Fields(text)[0])
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+		command = message.Command()
+	}
+
+	return &tgbot.Context{
+		ChatId:  chat.ID,
+		UserId:  userId,
+		Command: command,
+		Update:  tgbotapi.Update{Message: message},
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	tests := []struct {
+		name         string
+		registration string
+		userId       int64
+		text         string
+		group        bool
+		wantAborted  bool
+		wantMessage  string
+		wantRole     string
+	}{
+		{name: "admin", registration: config.RegistrationInvite, userId: adminId, text: "/week", wantRole: RoleAdmin},
+		{name: "blocked user", registration: config.RegistrationInvite, userId: blockedId, text: "/week", wantAborted: true, wantMessage: "I can't talk to you", wantRole: RoleBlocked},
+		{name: "blocked user in a group", registration: config.RegistrationInvite, userId: blockedId, text: "/week", group: true, wantAborted: true, wantRole: RoleBlocked},
+		{name: "new user without invite", registration: config.RegistrationInvite, userId: newUserId, text: "/start", wantAborted: true, wantMessage: "Ask an admin"},
+		{name: "new user with other command", registration: config.RegistrationInvite, userId: newUserId, text: "/week valid", wantAborted: true, wantMessage: "Ask an admin"},
+		{name: "new user with invite", registration: config.RegistrationInvite, userId: newUserId, text: "/start valid", wantRole: RoleAdmin},
+		{name: "used invite", registration: config.RegistrationInvite, userId: newUserId, text: "/start used", wantAborted: true, wantMessage: "not valid anymore"},
+		{name: "expired invite", registration: config.RegistrationInvite, userId: newUserId, text: "/start expired", wantAborted: true, wantMessage: "not valid anymore"},
+		{name: "unknown invite", registration: config.RegistrationInvite, userId: newUserId, text: "/start other", wantAborted: true, wantMessage: "not valid anymore"},
+		{name: "open registration", registration: config.RegistrationOpen, userId: newUserId, text: "hello", wantRole: RoleUser},
+		{name: "open registration ignores invites", registration: config.RegistrationOpen, userId: newUserId, text: "/start valid", wantRole: RoleUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, tt.registration)
+			c := newTestContext(tt.userId, tt.text, tt.group)
+
+			a.IsAllowed(c)
+
+			if c.IsAborted() != tt.wantAborted {
+				t.Errorf("aborted = %v, want %v", c.IsAborted(), tt.wantAborted)
+			}
+
+			messages := c.GetMessages()
+			switch {
+			case tt.wantMessage == "" && len(messages) > 0:
+				t.Errorf("got message %q, want none", messages[0].Text)
+			case tt.wantMessage != "" && (len(messages) != 1 || !strings.Contains(messages[0].Text, tt.wantMessage)):
+				t.Errorf("got messages %v, want %q", messages, tt.wantMessage)
+			}
+
+			user, err := a.users.GetUser(tt.userId)
+			if tt.wantRole == "" {
+				if !errors.Is(err, ErrUserNotFound) {
+					t.Errorf("GetUser() = %v, %v, want ErrUserNotFound", user, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetUser() error = %v", err)
+			}
+			if user.Role != tt.wantRole {
+				t.Errorf("role = %q, want %q", user.Role, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestUseInvite(t *testing.T) {
+	a := newTestApp(t, config.RegistrationInvite)
+	now := carbon.Now().Timestamp()
+
+	user := &User{UserId: newUserId, Name: "@user"}
+	invite, err := a.invites.UseInvite("valid", user, now)
+	if err != nil {
+		t.Fatalf("UseInvite() error = %v", err)
+	}
+	if invite.UsedBy == nil || *invite.UsedBy != newUserId {
+		t.Errorf("UsedBy = %v, want %d", invite.UsedBy, newUserId)
+	}
+
+	saved, err := a.users.GetUser(newUserId)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if saved.Role != RoleAdmin || saved.InvitedBy == nil || *saved.InvitedBy != adminId {
+		t.Errorf("saved user = %+v, want admin invited by %d", saved, adminId)
+	}
+
+	if _, err := a.invites.UseInvite("valid", &User{UserId: newUserId + 1}, now); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("second UseInvite() error = %v, want ErrInviteNotFound", err)
+	}
+	if _, err := a.users.GetUser(newUserId + 1); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("user of the second UseInvite() is saved: %v", err)
+	}
+}
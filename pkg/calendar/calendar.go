@@ -2,58 +2,74 @@ package calendar
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/metrics"
+	"github.com/ibovyrin/go-plan-it/pkg/tracing"
+	"go.opentelemetry.io/otel"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	gCalendar "google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
-	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"strings"
 )
 
+const revokeURL = "https://oauth2.googleapis.com/revoke"
+
+var ErrNotAttendee = errors.New("the user is not an attendee of the event")
+
+var tracer = otel.Tracer("github.com/ibovyrin/go-plan-it/pkg/calendar")
+
 type Calendar struct {
 	config     *oauth2.Config
 	webhookUrl string
 }
 
-func NewCalendar(oauth2ConfigFile string) (*Calendar, error) {
-	var webhookUrl = os.Getenv("WEBHOOK_URL")
-
-	if webhookUrl == "" {
-		return nil, fmt.Errorf("WEBHOOK_URL env variable is not set")
+// NewCalendar creates a calendar client, scopes of other Google APIs used with
+// the same token can be requested with extraScopes.
+func NewCalendar(config config.Google, extraScopes ...string) (*Calendar, error) {
+	if config.WebhookURL == "" {
+		return nil, fmt.Errorf("google webhook url is not set")
 
 	}
 
-	fileBytes, err := os.ReadFile(oauth2ConfigFile)
+	fileBytes, err := os.ReadFile(config.CredentialsFile)
 	if err != nil {
-		log.Fatalf("Failed to read desktop.json: %v", err)
+		return nil, fmt.Errorf("failed to read oauth2ConfigFile: %w", err)
 	}
 
-	config, err := google.ConfigFromJSON(fileBytes, gCalendar.CalendarScope)
+	oauth2Config, err := google.ConfigFromJSON(fileBytes, append([]string{gCalendar.CalendarScope}, extraScopes...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse oauth2ConfigFile: %w", err)
 	}
 
 	return &Calendar{
-		config:     config,
-		webhookUrl: webhookUrl,
+		config:     oauth2Config,
+		webhookUrl: config.WebhookURL,
 	}, nil
 }
 
-func (c *Calendar) createService(ctx context.Context, token *oauth2.Token) (*gCalendar.Service, error) {
-	client := c.config.Client(ctx, token)
+func (c *Calendar) createService(ctx context.Context, method string, ts oauth2.TokenSource) (*gCalendar.Service, error) {
+	client := oauth2.NewClient(ctx, ts)
+	client.Transport = metrics.GoogleTransport("calendar", method, client.Transport)
 	return gCalendar.NewService(ctx, option.WithHTTPClient(client))
 }
 
-func (c *Calendar) GetEventByID(ctx context.Context, eventId string, calendarId string, token *oauth2.Token) (*gCalendar.Event, error) {
-	service, err := c.createService(ctx, token)
+func (c *Calendar) GetEventByID(ctx context.Context, eventId string, calendarId string, ts oauth2.TokenSource) (_ *gCalendar.Event, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.GetEventByID")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "GetEventByID", ts)
 	if err != nil {
 		return nil, fmt.Errorf("GetEventByID: failed to create calendar service: %w", err)
 	}
 
-	e, err := service.Events.Get(calendarId, eventId).Do()
+	e, err := service.Events.Get(calendarId, eventId).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("GetEventByID: failed to fetch calendar events: %w", err)
 	}
@@ -61,8 +77,11 @@ func (c *Calendar) GetEventByID(ctx context.Context, eventId string, calendarId
 	return e, nil
 }
 
-func (c *Calendar) GetCalendarsList(ctx context.Context, token *oauth2.Token) ([]*gCalendar.CalendarListEntry, error) {
-	service, err := c.createService(ctx, token)
+func (c *Calendar) GetCalendarsList(ctx context.Context, ts oauth2.TokenSource) (_ []*gCalendar.CalendarListEntry, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.GetCalendarsList")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "GetCalendarsList", ts)
 	if err != nil {
 		return nil, fmt.Errorf("GetCalendars: failed to create calendar service: %w", err)
 	}
@@ -77,7 +96,7 @@ func (c *Calendar) GetCalendarsList(ctx context.Context, token *oauth2.Token) ([
 			call.PageToken(pageToken)
 		}
 
-		r, err := call.Do()
+		r, err := call.Context(ctx).Do()
 		if err != nil {
 			return nil, fmt.Errorf("GetCalendars: failed to fetch calendar list: %w", err)
 		}
@@ -92,8 +111,11 @@ func (c *Calendar) GetCalendarsList(ctx context.Context, token *oauth2.Token) ([
 	return response, nil
 }
 
-func (c *Calendar) GetEventsList(ctx context.Context, calendarId, start, end string, maxResults int64, token *oauth2.Token) ([]*gCalendar.Event, error) {
-	service, err := c.createService(ctx, token)
+func (c *Calendar) GetEventsList(ctx context.Context, calendarId, start, end string, maxResults int64, ts oauth2.TokenSource) (_ []*gCalendar.Event, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.GetEventsList")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "GetEventsList", ts)
 	if err != nil {
 		return nil, fmt.Errorf("GetEventsList: failed to create calendar service: %w", err)
 	}
@@ -107,7 +129,7 @@ func (c *Calendar) GetEventsList(ctx context.Context, calendarId, start, end str
 			call.PageToken(pageToken)
 		}
 
-		r, err := call.Do()
+		r, err := call.Context(ctx).Do()
 		if err != nil {
 			return nil, fmt.Errorf("GetEventsList: failed to fetch calendar events: %w", err)
 		}
@@ -122,24 +144,132 @@ func (c *Calendar) GetEventsList(ctx context.Context, calendarId, start, end str
 	return response, nil
 }
 
-func (c *Calendar) CreateEvent(ctx context.Context, calendarId string, event *gCalendar.Event, token *oauth2.Token) error {
-	service, err := c.createService(ctx, token)
+func (c *Calendar) CreateEvent(ctx context.Context, calendarId string, event *gCalendar.Event, ts oauth2.TokenSource) (err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.CreateEvent")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "CreateEvent", ts)
 	if err != nil {
 		return fmt.Errorf("CreateEvent: failed to create calendar service: %w", err)
 	}
 
-	call, err := service.Events.Insert(calendarId, event).Do()
+	call := service.Events.Insert(calendarId, event)
+	if len(event.Attendees) > 0 {
+		call.SendUpdates("all")
+	}
+	if event.ConferenceData != nil {
+		call.ConferenceDataVersion(1)
+	}
 
+	created, err := call.Context(ctx).Do()
 	if err != nil {
 		return fmt.Errorf("CreateEvent: failed to create task: %w", err)
 	}
-	event.HtmlLink = call.HtmlLink
+	*event = *created
 
 	return nil
 }
 
-func (c *Calendar) CreateWatchChannel(ctx context.Context, calendarId, webhookPath string, token *oauth2.Token) (*gCalendar.Channel, error) {
-	service, err := c.createService(ctx, token)
+// RespondToEvent sets the response status of the self attendee of the event and
+// notifies the organizer.
+func (c *Calendar) RespondToEvent(ctx context.Context, calendarId, eventId, responseStatus string, ts oauth2.TokenSource) (_ *gCalendar.Event, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.RespondToEvent")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "RespondToEvent", ts)
+	if err != nil {
+		return nil, fmt.Errorf("RespondToEvent: failed to create calendar service: %w", err)
+	}
+
+	event, err := service.Events.Get(calendarId, eventId).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("RespondToEvent: failed to get event: %w", err)
+	}
+
+	found := false
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			attendee.ResponseStatus = responseStatus
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("RespondToEvent: %w", ErrNotAttendee)
+	}
+
+	// attendees are replaced as a whole by patch, so the full list is sent back
+	e, err := service.Events.Patch(calendarId, eventId, &gCalendar.Event{Attendees: event.Attendees}).SendUpdates("all").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("RespondToEvent: failed to patch event: %w", err)
+	}
+
+	return e, nil
+}
+
+// NewMeetRequest returns conference data which makes CreateEvent add a Google Meet link.
+func NewMeetRequest() *gCalendar.ConferenceData {
+	return &gCalendar.ConferenceData{
+		CreateRequest: &gCalendar.CreateConferenceRequest{
+			RequestId:             uuid.New().String(),
+			ConferenceSolutionKey: &gCalendar.ConferenceSolutionKey{Type: "hangoutsMeet"},
+		},
+	}
+}
+
+// JoinURL returns the link to join the video conference of the event, if it has one.
+func JoinURL(event *gCalendar.Event) string {
+	if event.HangoutLink != "" {
+		return event.HangoutLink
+	}
+	if event.ConferenceData != nil {
+		for _, entry := range event.ConferenceData.EntryPoints {
+			if entry.EntryPointType == "video" && entry.Uri != "" {
+				return entry.Uri
+			}
+		}
+	}
+	return ""
+}
+
+// GetUpdatedEvents returns events changed since updatedMin, recurring events are not expanded.
+func (c *Calendar) GetUpdatedEvents(ctx context.Context, calendarId, updatedMin string, ts oauth2.TokenSource) (_ []*gCalendar.Event, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.GetUpdatedEvents")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "GetUpdatedEvents", ts)
+	if err != nil {
+		return nil, fmt.Errorf("GetUpdatedEvents: failed to create calendar service: %w", err)
+	}
+
+	response := make([]*gCalendar.Event, 0)
+	pageToken := ""
+
+	for {
+		call := service.Events.List(calendarId).ShowDeleted(false).UpdatedMin(updatedMin).MaxResults(250)
+		if pageToken != "" {
+			call.PageToken(pageToken)
+		}
+
+		r, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("GetUpdatedEvents: failed to fetch calendar events: %w", err)
+		}
+
+		response = append(response, r.Items...)
+		pageToken = r.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return response, nil
+}
+
+func (c *Calendar) CreateWatchChannel(ctx context.Context, calendarId, webhookPath string, ts oauth2.TokenSource) (_ *gCalendar.Channel, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.CreateWatchChannel")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "CreateWatchChannel", ts)
 	if err != nil {
 		return nil, fmt.Errorf("CreateWatchChannel: failed to create calendar service: %w", err)
 	}
@@ -155,7 +285,7 @@ func (c *Calendar) CreateWatchChannel(ctx context.Context, calendarId, webhookPa
 		Type:    "web_hook",
 	}
 
-	response, err := service.Events.Watch(calendarId, channel).Do()
+	response, err := service.Events.Watch(calendarId, channel).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("CreateWatchChannel: failed to watch calendar: %w", err)
 	}
@@ -163,8 +293,11 @@ func (c *Calendar) CreateWatchChannel(ctx context.Context, calendarId, webhookPa
 	return response, nil
 }
 
-func (c *Calendar) DeleteWatchChannel(ctx context.Context, channelId, resourceId string, token *oauth2.Token) error {
-	service, err := c.createService(ctx, token)
+func (c *Calendar) DeleteWatchChannel(ctx context.Context, channelId, resourceId string, ts oauth2.TokenSource) (err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.DeleteWatchChannel")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "DeleteWatchChannel", ts)
 	if err != nil {
 		return fmt.Errorf("DeleteWatchChannel: failed to create calendar service: %w", err)
 	}
@@ -172,7 +305,7 @@ func (c *Calendar) DeleteWatchChannel(ctx context.Context, channelId, resourceId
 	err = service.Channels.Stop(&gCalendar.Channel{
 		Id:         channelId,
 		ResourceId: resourceId,
-	}).Do()
+	}).Context(ctx).Do()
 
 	if err != nil {
 		return fmt.Errorf("DeleteWatchChannel: failed to stop watching calendar: %w", err)
@@ -182,10 +315,14 @@ func (c *Calendar) DeleteWatchChannel(ctx context.Context, channelId, resourceId
 }
 
 func (c *Calendar) GetAuthURL(state string) string {
-	return c.config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	// prompt=consent makes Google issue a new refresh token when the user logs in again
+	return c.config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("prompt", "consent"))
 }
 
-func (c *Calendar) ExchangeCode(ctx context.Context, code string) (*oauth2.Token, error) {
+func (c *Calendar) ExchangeCode(ctx context.Context, code string) (_ *oauth2.Token, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.ExchangeCode")
+	defer func() { tracing.End(span, err) }()
+
 	token, err := c.config.Exchange(ctx, code, oauth2.AccessTypeOffline)
 	if err != nil {
 		return nil, fmt.Errorf("failed to exchange token: %w", err)
@@ -193,3 +330,95 @@ func (c *Calendar) ExchangeCode(ctx context.Context, code string) (*oauth2.Token
 
 	return token, nil
 }
+
+// RevokeToken revokes the whole grant of the token at Google.
+func (c *Calendar) RevokeToken(ctx context.Context, token *oauth2.Token) (err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.RevokeToken")
+	defer func() { tracing.End(span, err) }()
+
+	value := token.RefreshToken
+	if value == "" {
+		value = token.AccessToken
+	}
+
+	form := url.Values{"token": {value}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("RevokeToken: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Transport: metrics.GoogleTransport("oauth2", "RevokeToken", nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("RevokeToken: failed to revoke token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 400 means that the token is already expired or revoked
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("RevokeToken: unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// PatchEvent updates only the fields set in patch and returns the updated event.
+func (c *Calendar) PatchEvent(ctx context.Context, calendarId, eventId string, patch *gCalendar.Event, ts oauth2.TokenSource) (_ *gCalendar.Event, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.PatchEvent")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "PatchEvent", ts)
+	if err != nil {
+		return nil, fmt.Errorf("PatchEvent: failed to create calendar service: %w", err)
+	}
+
+	e, err := service.Events.Patch(calendarId, eventId, patch).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("PatchEvent: failed to patch event: %w", err)
+	}
+
+	return e, nil
+}
+
+// FreeBusy returns busy periods of all calendars between start and end.
+func (c *Calendar) FreeBusy(ctx context.Context, calendarIds []string, start, end string, ts oauth2.TokenSource) (_ []Period, err error) {
+	ctx, span := tracer.Start(ctx, "Calendar.FreeBusy")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := c.createService(ctx, "FreeBusy", ts)
+	if err != nil {
+		return nil, fmt.Errorf("FreeBusy: failed to create calendar service: %w", err)
+	}
+
+	items := make([]*gCalendar.FreeBusyRequestItem, 0, len(calendarIds))
+	for _, id := range calendarIds {
+		items = append(items, &gCalendar.FreeBusyRequestItem{Id: id})
+	}
+
+	r, err := service.Freebusy.Query(&gCalendar.FreeBusyRequest{
+		TimeMin: start,
+		TimeMax: end,
+		Items:   items,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("FreeBusy: failed to query free busy: %w", err)
+	}
+
+	busy := make([]Period, 0)
+	for id, cld := range r.Calendars {
+		if len(cld.Errors) > 0 {
+			return nil, fmt.Errorf("FreeBusy: failed to query calendar %s: %s", id, cld.Errors[0].Reason)
+		}
+
+		for _, p := range cld.Busy {
+			period, err := ParsePeriod(p.Start, p.End)
+			if err != nil {
+				return nil, fmt.Errorf("FreeBusy: %w", err)
+			}
+			busy = append(busy, period)
+		}
+	}
+
+	return busy, nil
+}
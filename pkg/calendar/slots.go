@@ -0,0 +1,128 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Period is a time interval, End is exclusive.
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+func ParsePeriod(start, end string) (Period, error) {
+	s, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return Period{}, fmt.Errorf("failed to parse period start: %w", err)
+	}
+
+	e, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return Period{}, fmt.Errorf("failed to parse period end: %w", err)
+	}
+
+	return Period{Start: s, End: e}, nil
+}
+
+func (p Period) Overlaps(other Period) bool {
+	return p.Start.Before(other.End) && other.Start.Before(p.End)
+}
+
+type SlotOptions struct {
+	Duration time.Duration
+	// Buffer is the minimum free time between a slot and busy periods
+	Buffer time.Duration
+	// WorkStart and WorkEnd are the working hours as offsets from midnight
+	WorkStart time.Duration
+	WorkEnd   time.Duration
+	// Step is the granularity of slot starts
+	Step  time.Duration
+	Limit int
+}
+
+// FindSlots returns up to opts.Limit earliest free slots in the window which
+// are inside working hours and don't overlap busy periods with buffers around them.
+func FindSlots(window Period, busy []Period, opts SlotOptions) []Period {
+	if opts.Step <= 0 {
+		opts.Step = 15 * time.Minute
+	}
+
+	blocked := mergePeriods(busy, opts.Buffer)
+	slots := make([]Period, 0, opts.Limit)
+
+	loc := window.Start.Location()
+	day := time.Date(window.Start.Year(), window.Start.Month(), window.Start.Day(), 0, 0, 0, 0, loc)
+
+	for ; day.Before(window.End) && len(slots) < opts.Limit; day = day.AddDate(0, 0, 1) {
+		workStart := latest(day.Add(opts.WorkStart), window.Start)
+		workEnd := earliest(day.Add(opts.WorkEnd), window.End)
+
+		t := roundUp(workStart, day, opts.Step)
+		for !t.Add(opts.Duration).After(workEnd) && len(slots) < opts.Limit {
+			slot := Period{Start: t, End: t.Add(opts.Duration)}
+
+			if b, ok := firstOverlap(blocked, slot); ok {
+				t = roundUp(b.End, day, opts.Step)
+				continue
+			}
+
+			slots = append(slots, slot)
+			t = roundUp(slot.End, day, opts.Step)
+		}
+	}
+
+	return slots
+}
+
+// mergePeriods sorts periods, extends them by buffer and joins overlapping ones.
+func mergePeriods(periods []Period, buffer time.Duration) []Period {
+	sorted := make([]Period, 0, len(periods))
+	for _, p := range periods {
+		sorted = append(sorted, Period{Start: p.Start.Add(-buffer), End: p.End.Add(buffer)})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	merged := make([]Period, 0, len(sorted))
+	for _, p := range sorted {
+		if n := len(merged); n > 0 && !p.Start.After(merged[n-1].End) {
+			merged[n-1].End = latest(merged[n-1].End, p.End)
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+func firstOverlap(periods []Period, slot Period) (Period, bool) {
+	for _, p := range periods {
+		if p.Overlaps(slot) {
+			return p, true
+		}
+	}
+	return Period{}, false
+}
+
+// roundUp rounds t up to the step counting from the start of the day.
+func roundUp(t, day time.Time, step time.Duration) time.Time {
+	offset := t.Sub(day)
+	if rem := offset % step; rem != 0 {
+		offset += step - rem
+	}
+	return day.Add(offset)
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
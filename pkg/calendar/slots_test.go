@@ -0,0 +1,150 @@
+package calendar
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at returns the time on the day of January 2024 in UTC, like at(8, "09:30").
+func at(day int, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2024, time.January, day, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func period(day int, start, end string) Period {
+	return Period{Start: at(day, start), End: at(day, end)}
+}
+
+func TestMergePeriods(t *testing.T) {
+	tests := []struct {
+		name    string
+		periods []Period
+		buffer  time.Duration
+		want    []Period
+	}{
+		{name: "empty", periods: nil, want: []Period{}},
+		{
+			name:    "sorted and disjoint",
+			periods: []Period{period(8, "10:00", "11:00"), period(8, "09:00", "09:30")},
+			want:    []Period{period(8, "09:00", "09:30"), period(8, "10:00", "11:00")},
+		},
+		{
+			name:    "overlapping",
+			periods: []Period{period(8, "09:00", "10:30"), period(8, "10:00", "11:00")},
+			want:    []Period{period(8, "09:00", "11:00")},
+		},
+		{
+			name:    "contained",
+			periods: []Period{period(8, "09:00", "12:00"), period(8, "10:00", "11:00")},
+			want:    []Period{period(8, "09:00", "12:00")},
+		},
+		{
+			name:    "adjacent",
+			periods: []Period{period(8, "09:00", "10:00"), period(8, "10:00", "11:00")},
+			want:    []Period{period(8, "09:00", "11:00")},
+		},
+		{
+			name:    "joined by buffer",
+			periods: []Period{period(8, "09:00", "10:00"), period(8, "10:20", "11:00")},
+			buffer:  10 * time.Minute,
+			want:    []Period{period(8, "08:50", "11:10")},
+		},
+		{
+			name:    "apart with buffer",
+			periods: []Period{period(8, "09:00", "10:00"), period(8, "10:30", "11:00")},
+			buffer:  10 * time.Minute,
+			want:    []Period{period(8, "08:50", "10:10"), period(8, "10:20", "11:10")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergePeriods(tt.periods, tt.buffer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergePeriods() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSlots(t *testing.T) {
+	workDay := SlotOptions{
+		Duration:  30 * time.Minute,
+		WorkStart: 9 * time.Hour,
+		WorkEnd:   18 * time.Hour,
+		Limit:     3,
+	}
+
+	withBuffer := workDay
+	withBuffer.Buffer = 10 * time.Minute
+
+	hour := workDay
+	hour.Duration = time.Hour
+	hour.Step = 30 * time.Minute
+
+	tests := []struct {
+		name   string
+		window Period
+		busy   []Period
+		opts   SlotOptions
+		want   []Period
+	}{
+		{
+			name:   "free day",
+			window: period(8, "00:00", "23:59"),
+			opts:   workDay,
+			want:   []Period{period(8, "09:00", "09:30"), period(8, "09:30", "10:00"), period(8, "10:00", "10:30")},
+		},
+		{
+			name:   "window starts during the day",
+			window: period(8, "13:05", "23:59"),
+			opts:   workDay,
+			want:   []Period{period(8, "13:15", "13:45"), period(8, "13:45", "14:15"), period(8, "14:15", "14:45")},
+		},
+		{
+			name:   "busy periods are skipped",
+			window: period(8, "00:00", "23:59"),
+			busy:   []Period{period(8, "09:00", "10:00"), period(8, "10:30", "11:00")},
+			opts:   workDay,
+			want:   []Period{period(8, "10:00", "10:30"), period(8, "11:00", "11:30"), period(8, "11:30", "12:00")},
+		},
+		{
+			name:   "buffer around busy periods",
+			window: period(8, "00:00", "23:59"),
+			busy:   []Period{period(8, "09:00", "10:00")},
+			opts:   withBuffer,
+			want:   []Period{period(8, "10:15", "10:45"), period(8, "10:45", "11:15"), period(8, "11:15", "11:45")},
+		},
+		{
+			name:   "next day when the day is busy",
+			window: Period{Start: at(8, "00:00"), End: at(9, "23:59")},
+			busy:   []Period{period(8, "08:00", "17:45")},
+			opts:   workDay,
+			want:   []Period{period(9, "09:00", "09:30"), period(9, "09:30", "10:00"), period(9, "10:00", "10:30")},
+		},
+		{
+			name:   "slot must end before work hours end",
+			window: period(8, "17:00", "23:59"),
+			opts:   hour,
+			want:   []Period{period(8, "17:00", "18:00")},
+		},
+		{
+			name:   "no free time",
+			window: period(8, "00:00", "23:59"),
+			busy:   []Period{period(8, "08:00", "19:00")},
+			opts:   workDay,
+			want:   []Period{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindSlots(tt.window, tt.busy, tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindSlots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"sync"
+)
+
+var ErrTokenRevoked = errors.New("oauth2 token is revoked or expired")
+
+// TokenHandler is notified when a token used by the calendar client changes.
+type TokenHandler interface {
+	// TokenRefreshed is called with a new access token, it should be persisted.
+	TokenRefreshed(token *oauth2.Token) error
+	// TokenRevoked is called when the refresh token is no longer valid.
+	TokenRevoked(err error)
+}
+
+type tokenSource struct {
+	mu      sync.Mutex
+	base    oauth2.TokenSource
+	current *oauth2.Token
+	handler TokenHandler
+}
+
+// TokenSource returns a token source which refreshes the token when needed and
+// reports refreshed and revoked tokens to the handler.
+func (c *Calendar) TokenSource(token *oauth2.Token, handler TokenHandler) oauth2.TokenSource {
+	return &tokenSource{
+		base:    c.config.TokenSource(context.Background(), token),
+		current: token,
+		handler: handler,
+	}
+}
+
+func (t *tokenSource) Token() (*oauth2.Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token, err := t.base.Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			err = fmt.Errorf("%w: %w", ErrTokenRevoked, err)
+			t.handler.TokenRevoked(err)
+		}
+		return nil, err
+	}
+
+	if t.current == nil || token.AccessToken != t.current.AccessToken {
+		t.current = token
+		if err := t.handler.TokenRefreshed(token); err != nil {
+			return nil, fmt.Errorf("failed to save refreshed token: %w", err)
+		}
+	}
+
+	return token, nil
+}
@@ -0,0 +1,438 @@
+// Package config loads the configuration of the bot. Defaults are overridden by a YAML or TOML
+// file, env variables and command line flags, in this order.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RegistrationInvite = "invite"
+	RegistrationOpen   = "open"
+)
+
+type Config struct {
+	Telegram Telegram `yaml:"telegram" toml:"telegram"`
+	Access   Access   `yaml:"access" toml:"access"`
+	OpenAI   OpenAI   `yaml:"openai" toml:"openai"`
+	Google   Google   `yaml:"google" toml:"google"`
+	Database Database `yaml:"database" toml:"database"`
+	Server   Server   `yaml:"server" toml:"server"`
+	Schedule Schedule `yaml:"schedule" toml:"schedule"`
+	Log      Log      `yaml:"log" toml:"log"`
+	Tracing  Tracing  `yaml:"tracing" toml:"tracing"`
+}
+
+type Telegram struct {
+	Token string `yaml:"token" toml:"token"`
+	// Workers is the number of updates handled concurrently
+	Workers int `yaml:"workers" toml:"workers"`
+	// UpdatesTimeout is the long polling timeout in seconds
+	UpdatesTimeout int `yaml:"updates_timeout" toml:"updates_timeout"`
+	// WebhookURL is the public URL of the server, updates are received with long polling if it's empty
+	WebhookURL    string `yaml:"webhook_url" toml:"webhook_url"`
+	WebhookSecret string `yaml:"webhook_secret" toml:"webhook_secret"`
+}
+
+type Access struct {
+	// Admins are telegram user ids which are made admins on start
+	Admins []int64 `yaml:"admins" toml:"admins"`
+	// Registration is RegistrationInvite or RegistrationOpen
+	Registration string `yaml:"registration" toml:"registration"`
+	// AllowList is not supported anymore, it is kept to warn about it
+	AllowList string `yaml:"allow_list" toml:"allow_list"`
+}
+
+type OpenAI struct {
+	Token string `yaml:"token" toml:"token"`
+}
+
+type Google struct {
+	// CredentialsFile is the OAuth 2.0 client file from the Google API Console
+	CredentialsFile string `yaml:"credentials_file" toml:"credentials_file"`
+	// WebhookURL receives calendar notifications, the chat id is appended to it
+	WebhookURL string `yaml:"webhook_url" toml:"webhook_url"`
+}
+
+type Database struct {
+	// DSN is a PostgreSQL connection string or a path to a SQLite file
+	DSN string `yaml:"dsn" toml:"dsn"`
+	// EncryptionKeys are "id:base64" keys separated by commas, the active key goes first
+	EncryptionKeys    string `yaml:"encryption_keys" toml:"encryption_keys"`
+	EncryptionKeyFile string `yaml:"encryption_key_file" toml:"encryption_key_file"`
+}
+
+type Server struct {
+	Address string `yaml:"address" toml:"address"`
+	// AdminPassword enables the admin page if it's set
+	AdminPassword   string   `yaml:"admin_password" toml:"admin_password"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// Schedule has cron expressions with seconds of scheduled jobs.
+type Schedule struct {
+	Notifications string `yaml:"notifications" toml:"notifications"`
+	MorningUpdate string `yaml:"morning_update" toml:"morning_update"`
+}
+
+type Log struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level" toml:"level"`
+	// Format is text or json
+	Format string `yaml:"format" toml:"format"`
+	// Redact removes message texts and secrets from logs
+	Redact bool `yaml:"redact" toml:"redact"`
+}
+
+type Tracing struct {
+	// Endpoint is the OTLP/HTTP traces URL, like http://localhost:4318/v1/traces, traces are not sent if it's empty
+	Endpoint    string `yaml:"endpoint" toml:"endpoint"`
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+	// SampleRatio is the share of traces which are sent, from 0 to 1
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// Duration is a time.Duration written like "30s" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the config used when nothing is set.
+func Default() *Config {
+	return &Config{
+		Telegram: Telegram{
+			Workers:        16,
+			UpdatesTimeout: 60,
+		},
+		Access: Access{
+			Registration: RegistrationInvite,
+		},
+		Google: Google{
+			CredentialsFile: "credentials.json",
+		},
+		Database: Database{
+			DSN: "gorm.db",
+		},
+		Server: Server{
+			Address:         "0.0.0.0:80",
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Schedule: Schedule{
+			Notifications: "*/5 * * * * *",
+			MorningUpdate: "00 45 8 * * *",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+			Redact: true,
+		},
+		Tracing: Tracing{
+			ServiceName: "go-plan-it",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// setting is a config value which can be overridden with an env variable and a flag,
+// flag is empty for secrets, which must not be visible in the process list.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value func(c *Config) any
+}
+
+var settings = []setting{
+	{"TG_BOT_TOKEN", "", "Telegram bot token", func(c *Config) any { return &c.Telegram.Token }},
+	{"TG_BOT_WORKERS", "telegram-workers", "number of updates handled concurrently", func(c *Config) any { return &c.Telegram.Workers }},
+	{"TG_BOT_UPDATES_TIMEOUT", "telegram-updates-timeout", "long polling timeout in seconds", func(c *Config) any { return &c.Telegram.UpdatesTimeout }},
+	{"TG_BOT_WEBHOOK_URL", "telegram-webhook-url", "public URL of the server to receive Telegram updates with a webhook", func(c *Config) any { return &c.Telegram.WebhookURL }},
+	{"TG_BOT_WEBHOOK_SECRET", "", "secret token of the Telegram webhook", func(c *Config) any { return &c.Telegram.WebhookSecret }},
+	{"TG_BOT_ADMINS", "admins", "comma separated telegram user ids which are made admins on start", func(c *Config) any { return &c.Access.Admins }},
+	{"TG_BOT_REGISTRATION", "registration", "how new users register: invite or open", func(c *Config) any { return &c.Access.Registration }},
+	{"TG_BOT_ALLOW_LIST", "", "not supported anymore", func(c *Config) any { return &c.Access.AllowList }},
+	{"OPENAI_TOKEN", "", "OpenAI API token", func(c *Config) any { return &c.OpenAI.Token }},
+	{"GOOGLE_CREDENTIALS_FILE", "google-credentials-file", "OAuth 2.0 client file from the Google API Console", func(c *Config) any { return &c.Google.CredentialsFile }},
+	{"WEBHOOK_URL", "google-webhook-url", "URL which receives Google Calendar notifications", func(c *Config) any { return &c.Google.WebhookURL }},
+	{"DB_DSN", "db-dsn", "PostgreSQL connection string or SQLite file path", func(c *Config) any { return &c.Database.DSN }},
+	{"DB_ENCRYPTION_KEYS", "", "comma separated id:base64 encryption keys, the active key goes first", func(c *Config) any { return &c.Database.EncryptionKeys }},
+	{"DB_ENCRYPTION_KEY_FILE", "db-encryption-key-file", "file with encryption keys, one per line", func(c *Config) any { return &c.Database.EncryptionKeyFile }},
+	{"SERVER_ADDRESS", "server-address", "address of the HTTP server", func(c *Config) any { return &c.Server.Address }},
+	{"ADMIN_PASSWORD", "", "password of the admin page", func(c *Config) any { return &c.Server.AdminPassword }},
+	{"SERVER_SHUTDOWN_TIMEOUT", "server-shutdown-timeout", "how long to wait for in-flight work on shutdown", func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{"SCHEDULE_NOTIFICATIONS", "schedule-notifications", "cron expression with seconds of event notifications", func(c *Config) any { return &c.Schedule.Notifications }},
+	{"SCHEDULE_MORNING_UPDATE", "schedule-morning-update", "cron expression with seconds of the morning agenda", func(c *Config) any { return &c.Schedule.MorningUpdate }},
+	{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error", func(c *Config) any { return &c.Log.Level }},
+	{"LOG_FORMAT", "log-format", "log format: text or json", func(c *Config) any { return &c.Log.Format }},
+	{"LOG_REDACT", "log-redact", "remove message texts and secrets from logs", func(c *Config) any { return &c.Log.Redact }},
+	{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "tracing-endpoint", "OTLP/HTTP traces URL", func(c *Config) any { return &c.Tracing.Endpoint }},
+	{"OTEL_SERVICE_NAME", "tracing-service-name", "service name of traces", func(c *Config) any { return &c.Tracing.ServiceName }},
+	{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "share of traces which are sent, from 0 to 1", func(c *Config) any { return &c.Tracing.SampleRatio }},
+}
+
+// Load builds the config from args, which are command line arguments without the program name.
+// The config file is set with the -config flag or CONFIG_FILE env variable. Arguments after
+// the flags, like a subcommand, are returned.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("go-plan-it", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config `file`, $CONFIG_FILE")
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.String(s.flag, "", fmt.Sprintf("%s, $%s", s.usage, s.env))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	c := Default()
+	if *file != "" {
+		if err := c.readFile(*file); err != nil {
+			return nil, nil, fmt.Errorf("Load: %w", err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := set(s.value(c), v); err != nil {
+				return nil, nil, fmt.Errorf("Load: %s env variable is invalid: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if e := set(s.value(c), f.Value.String()); e != nil {
+					err = fmt.Errorf("Load: -%s flag is invalid: %w", f.Name, e)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, fs.Args(), nil
+}
+
+// readFile decodes the file by its extension, unknown keys are rejected to catch typos.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// an empty file is a valid config
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			var strict *toml.StrictMissingError
+			if errors.As(err, &strict) {
+				return fmt.Errorf("failed to parse %s: unknown keys:\n%s", path, strict.String())
+			}
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file must have .yaml, .yml or .toml extension: %s", path)
+	}
+	return nil
+}
+
+// set parses v into the config field pointed by p.
+func set(p any, v string) error {
+	var err error
+	switch p := p.(type) {
+	case *string:
+		*p = v
+	case *int:
+		*p, err = strconv.Atoi(v)
+	case *bool:
+		*p, err = strconv.ParseBool(v)
+	case *float64:
+		*p, err = strconv.ParseFloat(v, 64)
+	case *Duration:
+		err = p.UnmarshalText([]byte(v))
+	case *[]int64:
+		ids := make([]int64, 0)
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("must contain comma separated numbers: %w", err)
+			}
+			ids = append(ids, id)
+		}
+		*p = ids
+	default:
+		return fmt.Errorf("unsupported type %T", p)
+	}
+	return err
+}
+
+// Validate returns all problems of the config joined together.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Telegram.Token != "", "telegram.token is not set ($TG_BOT_TOKEN)")
+	check(c.Telegram.Workers > 0, "telegram.workers must be a positive number")
+	check(c.Telegram.UpdatesTimeout >= 0, "telegram.updates_timeout must not be negative")
+	if c.Telegram.WebhookURL != "" {
+		check(isURL(c.Telegram.WebhookURL), "telegram.webhook_url must be an absolute URL")
+		check(c.Telegram.WebhookSecret != "", "telegram.webhook_secret is not set ($TG_BOT_WEBHOOK_SECRET)")
+	}
+
+	check(c.Access.Registration == RegistrationInvite || c.Access.Registration == RegistrationOpen,
+		"access.registration must be %s or %s", RegistrationInvite, RegistrationOpen)
+
+	check(c.OpenAI.Token != "", "openai.token is not set ($OPENAI_TOKEN)")
+
+	check(isURL(c.Google.WebhookURL), "google.webhook_url must be an absolute URL ($WEBHOOK_URL)")
+	if _, err := os.Stat(c.Google.CredentialsFile); err != nil {
+		errs = append(errs, fmt.Errorf("google.credentials_file is not readable: %w", err))
+	}
+
+	check(c.Database.DSN != "", "database.dsn is not set ($DB_DSN)")
+	check(c.Database.EncryptionKeys != "" || c.Database.EncryptionKeyFile != "",
+		"database.encryption_keys or database.encryption_key_file is not set ($DB_ENCRYPTION_KEYS, $DB_ENCRYPTION_KEY_FILE)")
+
+	check(c.Server.Address != "", "server.address is not set")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+
+	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	if _, err := parser.Parse(c.Schedule.Notifications); err != nil {
+		errs = append(errs, fmt.Errorf("schedule.notifications is invalid: %w", err))
+	}
+	if _, err := parser.Parse(c.Schedule.MorningUpdate); err != nil {
+		errs = append(errs, fmt.Errorf("schedule.morning_update is invalid: %w", err))
+	}
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level must be debug, info, warn or error")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+
+	if c.Tracing.Endpoint != "" {
+		check(isURL(c.Tracing.Endpoint), "tracing.endpoint must be an absolute URL")
+		check(c.Tracing.ServiceName != "", "tracing.service_name is not set")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be from 0 to 1")
+
+	return errors.Join(errs...)
+}
+
+// Warnings returns settings which are set but not supported anymore.
+func (c *Config) Warnings() []string {
+	warnings := make([]string, 0)
+	if c.Access.AllowList != "" {
+		warnings = append(warnings, "access.allow_list ($TG_BOT_ALLOW_LIST) is not supported anymore, use access.admins and invite links")
+	}
+	return warnings
+}
+
+// Secrets returns credentials which must be removed from logs.
+func (c *Config) Secrets() []string {
+	secrets := []string{
+		c.Telegram.Token,
+		c.Telegram.WebhookSecret,
+		c.OpenAI.Token,
+		c.Server.AdminPassword,
+		dsnPassword(c.Database.DSN),
+	}
+
+	keys := c.Database.EncryptionKeys
+	if keys == "" && c.Database.EncryptionKeyFile != "" {
+		// the keyring fails to start without the file, so a read error leaves nothing to redact
+		if data, err := os.ReadFile(c.Database.EncryptionKeyFile); err == nil {
+			keys = string(data)
+		}
+	}
+	return append(secrets, encryptionKeys(keys)...)
+}
+
+// encryptionKeys returns the base64 part of "id:base64" keys, they are redacted one by one,
+// so a single key in a log record is removed too.
+func encryptionKeys(keys string) []string {
+	secrets := make([]string, 0)
+	for _, key := range strings.FieldsFunc(keys, func(r rune) bool { return r == ',' || r == '\n' }) {
+		key = strings.TrimSpace(key)
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if _, encoded, ok := strings.Cut(key, ":"); ok {
+			key = encoded
+		}
+		secrets = append(secrets, key)
+	}
+	return secrets
+}
+
+// dsnPassword returns the password of a PostgreSQL connection string, in the URL or the key/value form.
+func dsnPassword(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		return password
+	}
+
+	for _, field := range strings.Fields(dsn) {
+		if password, ok := strings.CutPrefix(field, "password="); ok {
+			return strings.Trim(password, "'")
+		}
+	}
+	return ""
+}
+
+// SlogLevel returns the log level, info if it's invalid.
+func (l Log) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
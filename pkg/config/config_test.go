@@ -0,0 +1,255 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv hides env variables of the environment running the tests, empty values are ignored by Load.
+func clearEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	for _, s := range settings {
+		t.Setenv(s.env, "")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	yamlFile := `
+telegram:
+  workers: 4
+  token: file-token
+database:
+  dsn: file.db
+log:
+  level: warn
+access:
+  admins: [1, 2]
+`
+	tomlFile := `
+[telegram]
+workers = 8
+
+[server]
+shutdown_timeout = "5s"
+`
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		// fileFromEnv sets the file with CONFIG_FILE instead of the -config flag
+		fileFromEnv bool
+		env         map[string]string
+		args        []string
+		check       func(c *Config) any
+		want        any
+		wantArgs    []string
+		wantErr     string
+	}{
+		{name: "default", check: func(c *Config) any { return c.Telegram.Workers }, want: 16},
+		{name: "yaml file", file: "config.yaml", content: yamlFile, check: func(c *Config) any { return c.Telegram.Workers }, want: 4},
+		{name: "yaml keeps defaults", file: "config.yaml", content: yamlFile, check: func(c *Config) any { return c.Log.Format }, want: "text"},
+		{name: "yaml list", file: "config.yml", content: yamlFile, check: func(c *Config) any { return c.Access.Admins }, want: []int64{1, 2}},
+		{name: "toml file", file: "config.toml", content: tomlFile, check: func(c *Config) any { return c.Telegram.Workers }, want: 8},
+		{name: "toml duration", file: "config.toml", content: tomlFile, check: func(c *Config) any { return c.Server.ShutdownTimeout }, want: Duration(5 * time.Second)},
+		{name: "empty file", file: "config.yaml", content: "", check: func(c *Config) any { return c.Database.DSN }, want: "gorm.db"},
+		{
+			name:    "env overrides file",
+			file:    "config.yaml",
+			content: yamlFile,
+			env:     map[string]string{"TG_BOT_WORKERS": "6", "TG_BOT_TOKEN": "env-token"},
+			check:   func(c *Config) any { return []any{c.Telegram.Workers, c.Telegram.Token} },
+			want:    []any{6, "env-token"},
+		},
+		{
+			name:    "flag overrides env and file",
+			file:    "config.yaml",
+			content: yamlFile,
+			env:     map[string]string{"TG_BOT_WORKERS": "6", "DB_DSN": "env.db"},
+			args:    []string{"-telegram-workers", "2"},
+			check:   func(c *Config) any { return []any{c.Telegram.Workers, c.Database.DSN} },
+			want:    []any{2, "env.db"},
+		},
+		{
+			name:  "env list and bool",
+			env:   map[string]string{"TG_BOT_ADMINS": " 3, 4 ,", "LOG_REDACT": "false"},
+			check: func(c *Config) any { return []any{c.Access.Admins, c.Log.Redact} },
+			want:  []any{[]int64{3, 4}, false},
+		},
+		{
+			name:        "config file from env",
+			file:        "config.yaml",
+			content:     yamlFile,
+			fileFromEnv: true,
+			check:       func(c *Config) any { return c.Log.Level },
+			want:        "warn",
+		},
+		{
+			name:     "subcommand",
+			args:     []string{"-log-level", "debug", "migrate", "down", "1"},
+			check:    func(c *Config) any { return c.Log.Level },
+			want:     "debug",
+			wantArgs: []string{"migrate", "down", "1"},
+		},
+		{name: "unknown yaml key", file: "config.yaml", content: "telegram:\n  wrokers: 4\n", wantErr: "field wrokers not found"},
+		{name: "unknown toml key", file: "config.toml", content: "[telegram]\nwrokers = 4\n", wantErr: "unknown keys"},
+		{name: "unknown extension", file: "config.json", content: "{}", wantErr: "must have .yaml, .yml or .toml extension"},
+		{name: "invalid env", env: map[string]string{"TG_BOT_WORKERS": "many"}, wantErr: "TG_BOT_WORKERS env variable is invalid"},
+		{name: "invalid flag", args: []string{"-server-shutdown-timeout", "soon"}, wantErr: "-server-shutdown-timeout flag is invalid"},
+		{name: "unknown flag", args: []string{"-telegram-token", "secret"}, wantErr: "flag provided but not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			args := tt.args
+			if tt.file != "" {
+				path := writeFile(t, tt.file, tt.content)
+				if tt.fileFromEnv {
+					t.Setenv("CONFIG_FILE", path)
+				} else {
+					args = append([]string{"-config", path}, args...)
+				}
+			}
+
+			c, rest, err := Load(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if got := tt.check(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if len(rest) != len(tt.wantArgs) || (len(rest) > 0 && !reflect.DeepEqual(rest, tt.wantArgs)) {
+				t.Errorf("args = %v, want %v", rest, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	credentials := writeFile(t, "credentials.json", "{}")
+	valid := func() *Config {
+		c := Default()
+		c.Telegram.Token = "token"
+		c.OpenAI.Token = "token"
+		c.Google.CredentialsFile = credentials
+		c.Google.WebhookURL = "https://bot.example.com/webhook"
+		c.Database.EncryptionKeys = "k1:key"
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{name: "valid", change: func(c *Config) {}},
+		{name: "missing token", change: func(c *Config) { c.Telegram.Token = "" }, want: []string{"telegram.token is not set"}},
+		{name: "no workers", change: func(c *Config) { c.Telegram.Workers = 0 }, want: []string{"telegram.workers must be a positive number"}},
+		{
+			name:   "telegram webhook without secret",
+			change: func(c *Config) { c.Telegram.WebhookURL = "bot.example.com" },
+			want:   []string{"telegram.webhook_url must be an absolute URL", "telegram.webhook_secret is not set"},
+		},
+		{name: "unknown registration", change: func(c *Config) { c.Access.Registration = "closed" }, want: []string{"access.registration must be invite or open"}},
+		{name: "missing credentials file", change: func(c *Config) { c.Google.CredentialsFile = "missing.json" }, want: []string{"google.credentials_file is not readable"}},
+		{name: "key file instead of keys", change: func(c *Config) { c.Database.EncryptionKeys, c.Database.EncryptionKeyFile = "", "keys.txt" }},
+		{name: "no encryption keys", change: func(c *Config) { c.Database.EncryptionKeys = "" }, want: []string{"database.encryption_keys or database.encryption_key_file is not set"}},
+		{name: "cron without seconds", change: func(c *Config) { c.Schedule.MorningUpdate = "45 8 * * *" }, want: []string{"schedule.morning_update is invalid"}},
+		{name: "cron descriptor", change: func(c *Config) { c.Schedule.Notifications = "@every 1m" }},
+		{name: "log level", change: func(c *Config) { c.Log.Level = "verbose" }, want: []string{"log.level must be debug, info, warn or error"}},
+		{name: "sample ratio", change: func(c *Config) { c.Tracing.SampleRatio = 1.5 }, want: []string{"tracing.sample_ratio must be from 0 to 1"}},
+		{
+			name:   "all problems are reported",
+			change: func(c *Config) { c.OpenAI.Token, c.Server.Address, c.Log.Format = "", "", "xml" },
+			want:   []string{"openai.token is not set", "server.address is not set", "log.format must be text or json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(c)
+
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() error = nil, want %q", tt.want)
+			}
+			if got := strings.Count(err.Error(), "\n") + 1; got != len(tt.want) {
+				t.Errorf("Validate() returned %d problems, want %d: %v", got, len(tt.want), err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	tests := []struct {
+		name     string
+		database Database
+		// keyFile is the content of the encryption key file
+		keyFile string
+		want    []string
+	}{
+		{name: "sqlite", database: Database{DSN: "gorm.db"}},
+		{name: "postgres url", database: Database{DSN: "postgres://bot:p%40ss@db:5432/bot"}, want: []string{"p@ss"}},
+		{name: "postgres without password", database: Database{DSN: "postgres://bot@db:5432/bot"}},
+		{name: "postgres key value", database: Database{DSN: "host=db user=bot password='s3cret' dbname=bot"}, want: []string{"s3cret"}},
+		{name: "encryption keys", database: Database{EncryptionKeys: "k2:Zm9v, k1:YmFy\nYmF6"}, want: []string{"Zm9v", "YmFy", "YmF6"}},
+		{name: "encryption key file", keyFile: "# rotated: 2024\nk2:Zm9v\n\nk1:YmFy\n", want: []string{"Zm9v", "YmFy"}},
+		{name: "missing key file", database: Database{EncryptionKeyFile: "missing.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Database: tt.database}
+			if tt.keyFile != "" {
+				c.Database.EncryptionKeyFile = writeFile(t, "keys.txt", tt.keyFile)
+			}
+
+			got := make([]string, 0)
+			for _, secret := range c.Secrets() {
+				if secret != "" {
+					got = append(got, secret)
+				}
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("Secrets() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,8 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/metrics"
+	"github.com/ibovyrin/go-plan-it/pkg/tracing"
 	"github.com/sashabaranov/go-openai"
-	"os"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
 )
 
 const systemPrompt = `You are integrated into a scheduling system.
@@ -15,28 +21,71 @@ description: Contains the task's details.
 today: Indicates today's date.
 
 Your role is to analyze the request and respond with an object in valid JSON format.
-This object should contain three fields: title, notes, and date.
+This object should contain six fields: type, title, notes, date, attendees, and meet.
 
+type: "event" if the task happens at a specific time, like a meeting or an appointment, or "todo" if it is something to get done, like a chore or a purchase.
 title: The task's title.
 notes: A summary of the task.
-date: Extracted from the message, indicating when the task should be executed, in the same date format as received.
+date: Extracted from the message, indicating when the task should be executed, in the same date format as received. It is empty for a todo without a date.
+attendees: A list of people who should be invited to an event, as their names or email addresses exactly as written in the message. It is empty if nobody is mentioned.
+meet: true if the user asks for a video call, a Google Meet link or a Meet link, otherwise false.
 
 Instructions:
 If the incoming message is in the wrong format, you must respond with the error: "wrong format".
 You should Ensure that you correct any orthographical errors present in the message.
 You must respond in the same language as the original message in the description field.
-If a date is specified without a time, you should schedule the task for 09:30.
+If a date is specified without a time for an event, you should schedule the task for 09:30.
 
 Your response should be swift and accurate to facilitate effective task scheduling.`
 
+const (
+	TypeEvent = "event"
+	TypeTodo  = "todo"
+)
+
+const slotSystemPrompt = `You are integrated into a scheduling system.
+Users will send you messages, asking to find free time for a meeting or a task.
+Messages will always be in JSON format and should contain two fields: description and today.
+description: Contains the request's details.
+today: Indicates today's date.
+
+Your role is to analyze the request and respond with an object in valid JSON format.
+This object should contain five fields: title, notes, duration, from, and to.
+
+title: The title of the meeting or task.
+notes: A summary of the meeting or task, or an empty string if there are no details besides the title and the time.
+duration: How long it takes in minutes, as a number.
+from: The start of the period where the time should be found, in the same date format as received.
+to: The end of the period where the time should be found, in the same date format as received.
+
+Instructions:
+If the incoming message is in the wrong format, you must respond with the error: "wrong format".
+You must respond in the same language as the original message in the description field.
+If the duration is not specified, use 30 minutes.
+If the period is not specified, use the next 7 days starting from today.
+Morning is from 09:00 to 12:00, afternoon is from 12:00 to 18:00, evening is from 18:00 to 22:00.`
+
+var tracer = otel.Tracer("github.com/ibovyrin/go-plan-it/pkg/gpt")
+
 type GPT struct {
 	client *openai.Client
 }
 
 type Response struct {
-	Title string `json:"title"`
-	Date  string `json:"date"`
-	Notes string `json:"notes"`
+	Type      string   `json:"type"`
+	Title     string   `json:"title"`
+	Date      string   `json:"date"`
+	Notes     string   `json:"notes"`
+	Attendees []string `json:"attendees"`
+	Meet      bool     `json:"meet"`
+}
+
+type SlotResponse struct {
+	Title    string `json:"title"`
+	Notes    string `json:"notes"`
+	Duration int    `json:"duration"`
+	From     string `json:"from"`
+	To       string `json:"to"`
 }
 
 type Request struct {
@@ -49,14 +98,12 @@ var system = openai.ChatCompletionMessage{
 	Content: systemPrompt,
 }
 
-func NewGPT() (*GPT, error) {
-	var openAiToken = os.Getenv("OPENAI_TOKEN")
-
-	if openAiToken == "" {
-		return nil, fmt.Errorf("OPENAI_TOKEN env variable is not set")
+func NewGPT(config config.OpenAI) (*GPT, error) {
+	if config.Token == "" {
+		return nil, fmt.Errorf("openai token is not set")
 	}
 
-	client := openai.NewClient(openAiToken)
+	client := openai.NewClient(config.Token)
 
 	_, err := client.ListEngines(context.Background())
 	if err != nil {
@@ -65,16 +112,58 @@ func NewGPT() (*GPT, error) {
 	return &GPT{client: client}, nil
 }
 
-func (c *GPT) ParseRequest(request *Request) (Response, error) {
+// Ping returns an error if the OpenAI API is not reachable with the configured token.
+func (c *GPT) Ping(ctx context.Context) error {
+	if _, err := c.client.ListEngines(ctx); err != nil {
+		return fmt.Errorf("Ping: failed to list engines: %w", err)
+	}
+	return nil
+}
+
+func (c *GPT) ParseRequest(ctx context.Context, request *Request) (Response, error) {
 	var response Response
 
+	if err := c.complete(ctx, "ParseRequest", system, request, &response); err != nil {
+		return response, fmt.Errorf("ParseRequest: %w", err)
+	}
+
+	return response, nil
+}
+
+// ParseSlotRequest extracts the duration and the period of a request to find free time.
+func (c *GPT) ParseSlotRequest(ctx context.Context, request *Request) (SlotResponse, error) {
+	var response SlotResponse
+
+	err := c.complete(ctx, "ParseSlotRequest", openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: slotSystemPrompt,
+	}, request, &response)
+	if err != nil {
+		return response, fmt.Errorf("ParseSlotRequest: %w", err)
+	}
+
+	return response, nil
+}
+
+func (c *GPT) complete(ctx context.Context, method string, system openai.ChatCompletionMessage, request *Request, response any) (err error) {
+	ctx, span := tracer.Start(ctx, "GPT."+method, trace.WithAttributes(attribute.String("gpt.model", openai.GPT4)))
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	defer func() {
+		metrics.GPTDuration.WithLabelValues(method).Observe(metrics.Since(start))
+		if err != nil {
+			metrics.GPTErrors.WithLabelValues(method).Inc()
+		}
+	}()
+
 	data, err := json.Marshal(request)
 	if err != nil {
-		return response, fmt.Errorf("ParseRequest error: %w", err)
+		return fmt.Errorf("failed to json.Marshal: %w", err)
 	}
 
 	resp, err := c.client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model:            openai.GPT4,
 			Temperature:      1,
@@ -93,17 +182,23 @@ func (c *GPT) ParseRequest(request *Request) (Response, error) {
 	)
 
 	if err != nil {
-		return response, fmt.Errorf("ParseRequest error: %w", err)
+		return fmt.Errorf("failed to create chat completion: %w", err)
 	}
+	metrics.GPTTokens.WithLabelValues("prompt").Add(float64(resp.Usage.PromptTokens))
+	metrics.GPTTokens.WithLabelValues("completion").Add(float64(resp.Usage.CompletionTokens))
+	span.SetAttributes(
+		attribute.Int("gpt.prompt_tokens", resp.Usage.PromptTokens),
+		attribute.Int("gpt.completion_tokens", resp.Usage.CompletionTokens),
+	)
 
 	if len(resp.Choices) == 0 {
-		return response, fmt.Errorf("ParseRequest error: no completion")
+		return fmt.Errorf("no completion")
 	}
 
-	err = json.Unmarshal([]byte(resp.Choices[0].Message.Content), &response)
+	err = json.Unmarshal([]byte(resp.Choices[0].Message.Content), response)
 	if err != nil {
-		return response, fmt.Errorf("ParseRequest: failed to json.Unmarshal: %w", err)
+		return fmt.Errorf("failed to json.Unmarshal: %w", err)
 	}
 
-	return response, nil
+	return nil
 }
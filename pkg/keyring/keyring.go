@@ -0,0 +1,182 @@
+package keyring
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"os"
+	"strings"
+)
+
+const (
+	prefix  = "enc"
+	keySize = 32
+)
+
+var ErrNotEncrypted = errors.New("value is not encrypted")
+
+type key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring encrypts values with AES-GCM. The first key is used for encryption,
+// the rest are kept to decrypt values written before a key rotation.
+type Keyring struct {
+	keys   []key
+	active string
+}
+
+// NewKeyring loads keys from the config or, if they are empty, from the key file.
+// Keys have the form "id:base64(32 bytes)" and are separated by commas or new
+// lines, the active key goes first.
+func NewKeyring(config config.Database) (*Keyring, error) {
+	keys := config.EncryptionKeys
+
+	if keys == "" && config.EncryptionKeyFile == "" {
+		return nil, fmt.Errorf("encryption keys or key file is not set")
+	}
+
+	if keys == "" {
+		data, err := os.ReadFile(config.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		keys = string(data)
+	}
+
+	return ParseKeys(keys)
+}
+
+// ParseKeys creates a Keyring from a list of "id:base64" keys.
+func ParseKeys(s string) (*Keyring, error) {
+	k := &Keyring{}
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(s, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("ParseKeys: key must have the form id:base64")
+		}
+
+		if k.has(id) {
+			return nil, fmt.Errorf("ParseKeys: duplicated key id %q", id)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("ParseKeys: failed to decode key %q: %w", id, err)
+		}
+
+		if len(raw) != keySize {
+			return nil, fmt.Errorf("ParseKeys: key %q must be %d bytes long", id, keySize)
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("ParseKeys: failed to create cipher: %w", err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("ParseKeys: failed to create gcm: %w", err)
+		}
+
+		k.keys = append(k.keys, key{id: id, aead: aead})
+	}
+
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("ParseKeys: no keys found")
+	}
+
+	k.active = k.keys[0].id
+	return k, nil
+}
+
+func (k *Keyring) has(id string) bool {
+	for _, key := range k.keys {
+		if key.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *Keyring) get(id string) (key, bool) {
+	for _, key := range k.keys {
+		if key.id == id {
+			return key, true
+		}
+	}
+	return key{}, false
+}
+
+// Encrypt seals plaintext with the active key. additionalData is authenticated
+// but not stored, the same value must be passed to Decrypt.
+func (k *Keyring) Encrypt(plaintext, additionalData []byte) (string, error) {
+	active := k.keys[0]
+
+	nonce := make([]byte, active.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("Encrypt: failed to generate nonce: %w", err)
+	}
+
+	sealed := active.aead.Seal(nonce, nonce, plaintext, additionalData)
+	return fmt.Sprintf("%s:%s:%s", prefix, active.id, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt opens a value produced by Encrypt. It returns ErrNotEncrypted for
+// values written before encryption was enabled.
+func (k *Keyring) Decrypt(value string, additionalData []byte) ([]byte, error) {
+	id, sealed, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := k.get(id)
+	if !ok {
+		return nil, fmt.Errorf("Decrypt: unknown key id %q", id)
+	}
+
+	nonceSize := key.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("Decrypt: ciphertext is too short")
+	}
+
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt: failed to open ciphertext: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// NeedsRotation reports whether value is not encrypted with the active key.
+func (k *Keyring) NeedsRotation(value string) bool {
+	id, _, err := parse(value)
+	return err != nil || id != k.active
+}
+
+func parse(value string) (string, []byte, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] != prefix {
+		return "", nil, ErrNotEncrypted
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, fmt.Errorf("Decrypt: failed to decode ciphertext: %w", err)
+	}
+
+	return parts[1], sealed, nil
+}
@@ -0,0 +1,137 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	key1 = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", keySize)))
+	key2 = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("2", keySize)))
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		active  string
+		ids     []string
+		wantErr string
+	}{
+		{name: "single key", keys: "k1:" + key1, active: "k1", ids: []string{"k1"}},
+		{name: "comma separated", keys: "k2:" + key2 + ",k1:" + key1, active: "k2", ids: []string{"k2", "k1"}},
+		{name: "lines with comments", keys: "# rotated on monday\nk2:" + key2 + "\n\n k1:" + key1 + " \n", active: "k2", ids: []string{"k2", "k1"}},
+		{name: "empty", keys: " \n# nothing\n", wantErr: "no keys found"},
+		{name: "missing id", keys: ":" + key1, wantErr: "must have the form id:base64"},
+		{name: "missing separator", keys: key1, wantErr: "must have the form id:base64"},
+		{name: "duplicated id", keys: "k1:" + key1 + ",k1:" + key2, wantErr: `duplicated key id "k1"`},
+		{name: "invalid base64", keys: "k1:not base64", wantErr: `failed to decode key "k1"`},
+		{name: "short key", keys: "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), wantErr: `key "k1" must be 32 bytes long`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeys(tt.keys)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseKeys() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeys() error = %v", err)
+			}
+
+			if k.active != tt.active {
+				t.Errorf("active = %q, want %q", k.active, tt.active)
+			}
+			if len(k.keys) != len(tt.ids) {
+				t.Fatalf("got %d keys, want %d", len(k.keys), len(tt.ids))
+			}
+			for i, id := range tt.ids {
+				if k.keys[i].id != id {
+					t.Errorf("keys[%d] = %q, want %q", i, k.keys[i].id, id)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	old, err := ParseKeys("k1:" + key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ParseKeys("k2:" + key2 + ",k1:" + key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ParseKeys("k2:" + key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		encrypt      *Keyring
+		decrypt      *Keyring
+		ad           string
+		decryptAd    string
+		needRotation bool
+		wantErr      string
+	}{
+		{name: "same keyring", encrypt: rotated, decrypt: rotated, ad: "chat:1", decryptAd: "chat:1"},
+		{name: "written before rotation", encrypt: old, decrypt: rotated, ad: "chat:1", decryptAd: "chat:1", needRotation: true},
+		{name: "written after rotation", encrypt: rotated, decrypt: old, ad: "chat:1", decryptAd: "chat:1", wantErr: `unknown key id "k2"`},
+		{name: "old key removed", encrypt: old, decrypt: other, ad: "chat:1", decryptAd: "chat:1", wantErr: `unknown key id "k1"`},
+		{name: "other additional data", encrypt: rotated, decrypt: rotated, ad: "chat:1", decryptAd: "chat:2", wantErr: "failed to open ciphertext"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := "refresh token"
+			value, err := tt.encrypt.Encrypt([]byte(plaintext), []byte(tt.ad))
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			if strings.Contains(value, plaintext) {
+				t.Fatalf("Encrypt() = %q contains the plaintext", value)
+			}
+
+			got, err := tt.decrypt.Decrypt(value, []byte(tt.decryptAd))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decrypt() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if string(got) != plaintext {
+				t.Errorf("Decrypt() = %q, want %q", got, plaintext)
+			}
+			if rotation := tt.decrypt.NeedsRotation(value); rotation != tt.needRotation {
+				t.Errorf("NeedsRotation() = %v, want %v", rotation, tt.needRotation)
+			}
+		})
+	}
+}
+
+func TestDecryptNotEncrypted(t *testing.T) {
+	k, err := ParseKeys("k1:" + key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{"", "plain text", "enc:k1"} {
+		if _, err := k.Decrypt(value, nil); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("Decrypt(%q) error = %v, want ErrNotEncrypted", value, err)
+		}
+		if !k.NeedsRotation(value) {
+			t.Errorf("NeedsRotation(%q) = false, want true", value)
+		}
+	}
+}
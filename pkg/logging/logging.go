@@ -0,0 +1,83 @@
+// Package logging creates slog handlers from the log config and
+// correlation ids which tie log records of one update or request together.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	// RequestIdKey is the attribute with the correlation id of an update, a scheduled job or an HTTP request.
+	RequestIdKey = "request_id"
+	// Redacted replaces sensitive values in log records.
+	Redacted = "[REDACTED]"
+)
+
+// sensitiveKeys are attributes with message bodies and credentials which are never logged when redaction is on.
+var sensitiveKeys = map[string]bool{
+	"text":          true,
+	"password":      true,
+	"secret":        true,
+	"code":          true,
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// NewHandler creates a handler writing to w, redactor is used when redaction is on.
+func NewHandler(w io.Writer, config config.Log, redactor *Redactor) slog.Handler {
+	options := &slog.HandlerOptions{Level: config.SlogLevel()}
+	if config.Redact {
+		options.ReplaceAttr = redactor.ReplaceAttr
+	}
+
+	if config.Format == "json" {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+// Redactor replaces sensitive attributes and removes secrets, like API tokens, from all messages and values.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+func NewRedactor(secrets ...string) *Redactor {
+	pairs := make([]string, 0, len(secrets)*2)
+	for _, secret := range secrets {
+		if secret != "" {
+			pairs = append(pairs, secret, Redacted)
+		}
+	}
+	return &Redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// ReplaceAttr can be used as slog.HandlerOptions.ReplaceAttr.
+func (r *Redactor) ReplaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.replacer.Replace(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, r.replacer.Replace(err.Error()))
+		}
+	}
+	return a
+}
+
+// NewRequestId returns a random correlation id.
+func NewRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,119 @@
+// Package metrics defines the prometheus metrics of the bot, they are exposed on /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "goplanit"
+
+var (
+	TelegramUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_updates_total",
+		Help:      "Telegram updates by type and handled command.",
+	}, []string{"type", "command"})
+
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Duration of update handlers by command.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"command"})
+
+	ScheduledJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduled_job_duration_seconds",
+		Help:      "Duration of scheduled jobs.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60},
+	}, []string{"job"})
+
+	GPTDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gpt_request_duration_seconds",
+		Help:      "Duration of GPT requests by method.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"method"})
+
+	GPTErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gpt_errors_total",
+		Help:      "Failed GPT requests by method.",
+	}, []string{"method"})
+
+	GPTTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gpt_tokens_total",
+		Help:      "Tokens used by GPT requests by type, prompt or completion.",
+	}, []string{"type"})
+
+	GoogleRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "google_api_requests_total",
+		Help:      "Google API requests by api, method and HTTP status.",
+	}, []string{"api", "method", "status"})
+
+	GoogleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "google_api_request_duration_seconds",
+		Help:      "Duration of Google API requests by api and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api", "method"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Received webhooks by source and result.",
+	}, []string{"source", "result"})
+
+	RemindersSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_sent_total",
+		Help:      "Sent reminders by kind, event or snoozed.",
+	}, []string{"kind"})
+
+	ReminderLateness = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reminder_lateness_seconds",
+		Help:      "How late reminders are sent after they are due.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"kind"})
+)
+
+// Since returns seconds passed since start.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// googleTransport counts requests to a Google API made for one method of a client.
+type googleTransport struct {
+	api    string
+	method string
+	next   http.RoundTripper
+}
+
+// GoogleTransport wraps next to record requests of the api method, nil next means http.DefaultTransport.
+func GoogleTransport(api, method string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &googleTransport{api: api, method: method, next: next}
+}
+
+func (t *googleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	GoogleDuration.WithLabelValues(t.api, t.method).Observe(Since(start))
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	GoogleRequests.WithLabelValues(t.api, t.method, status).Inc()
+
+	return resp, err
+}
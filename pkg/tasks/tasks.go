@@ -0,0 +1,131 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ibovyrin/go-plan-it/pkg/metrics"
+	"github.com/ibovyrin/go-plan-it/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	gTasks "google.golang.org/api/tasks/v1"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Scope must be requested together with the calendar scope
+	Scope = gTasks.TasksScope
+
+	defaultTaskList = "@default"
+	statusCompleted = "completed"
+)
+
+var ErrInsufficientScope = errors.New("token doesn't allow access to google tasks")
+
+var tracer = otel.Tracer("github.com/ibovyrin/go-plan-it/pkg/tasks")
+
+// Tasks is a client for the default task list of the user.
+type Tasks struct{}
+
+func NewTasks() *Tasks {
+	return &Tasks{}
+}
+
+func (t *Tasks) createService(ctx context.Context, method string, ts oauth2.TokenSource) (*gTasks.Service, error) {
+	client := oauth2.NewClient(ctx, ts)
+	client.Transport = metrics.GoogleTransport("tasks", method, client.Transport)
+	return gTasks.NewService(ctx, option.WithHTTPClient(client))
+}
+
+// GetTasksList returns not completed tasks, due before dueMax if it's not empty.
+func (t *Tasks) GetTasksList(ctx context.Context, dueMax string, ts oauth2.TokenSource) (_ []*gTasks.Task, err error) {
+	ctx, span := tracer.Start(ctx, "Tasks.GetTasksList")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := t.createService(ctx, "GetTasksList", ts)
+	if err != nil {
+		return nil, fmt.Errorf("GetTasksList: failed to create tasks service: %w", err)
+	}
+
+	response := make([]*gTasks.Task, 0)
+	pageToken := ""
+
+	for {
+		call := service.Tasks.List(defaultTaskList).ShowCompleted(false).MaxResults(100)
+		if dueMax != "" {
+			call.DueMax(dueMax)
+		}
+		if pageToken != "" {
+			call.PageToken(pageToken)
+		}
+
+		r, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("GetTasksList: failed to fetch tasks: %w", wrapError(err))
+		}
+
+		response = append(response, r.Items...)
+		pageToken = r.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return response, nil
+}
+
+func (t *Tasks) CreateTask(ctx context.Context, task *gTasks.Task, ts oauth2.TokenSource) (_ *gTasks.Task, err error) {
+	ctx, span := tracer.Start(ctx, "Tasks.CreateTask")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := t.createService(ctx, "CreateTask", ts)
+	if err != nil {
+		return nil, fmt.Errorf("CreateTask: failed to create tasks service: %w", err)
+	}
+
+	created, err := service.Tasks.Insert(defaultTaskList, task).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("CreateTask: failed to create task: %w", wrapError(err))
+	}
+
+	return created, nil
+}
+
+func (t *Tasks) CompleteTask(ctx context.Context, taskId string, ts oauth2.TokenSource) (_ *gTasks.Task, err error) {
+	ctx, span := tracer.Start(ctx, "Tasks.CompleteTask")
+	defer func() { tracing.End(span, err) }()
+
+	service, err := t.createService(ctx, "CompleteTask", ts)
+	if err != nil {
+		return nil, fmt.Errorf("CompleteTask: failed to create tasks service: %w", err)
+	}
+
+	task, err := service.Tasks.Patch(defaultTaskList, taskId, &gTasks.Task{Status: statusCompleted}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("CompleteTask: failed to complete task: %w", wrapError(err))
+	}
+
+	return task, nil
+}
+
+// wrapError marks errors of tokens issued before the tasks scope was requested.
+func wrapError(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return err
+	}
+
+	for _, item := range apiErr.Errors {
+		if item.Reason == "insufficientPermissions" {
+			return fmt.Errorf("%w: %w", ErrInsufficientScope, err)
+		}
+	}
+	if strings.Contains(apiErr.Message, "insufficient authentication scopes") {
+		return fmt.Errorf("%w: %w", ErrInsufficientScope, err)
+	}
+
+	return err
+}
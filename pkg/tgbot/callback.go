@@ -0,0 +1,74 @@
+package tgbot
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/logging"
+	"strings"
+)
+
+const (
+	callbackSeparator = ":"
+	// MaxCallbackDataLength is the maximum length of inline keyboard button data
+	MaxCallbackDataLength = 64
+)
+
+// CallbackData builds data of an inline keyboard button which is handled by
+// the callback handler registered with the name. Telegram limits it to 64 bytes.
+func CallbackData(name string, args ...string) string {
+	return strings.Join(append([]string{name}, args...), callbackSeparator)
+}
+
+func (b *Bot) callbackHandlerName(name string) string {
+	return fmt.Sprintf("%s_callbackHandler", name)
+}
+
+// RegisterCallbackHandler registers handlers for inline keyboard buttons created with CallbackData(name, ...).
+func (b *Bot) RegisterCallbackHandler(name string, handlers []func(*Context)) {
+	b.RegisterCommand(b.callbackHandlerName(name), handlers)
+}
+
+func (b *Bot) handleCallbackQuery(update tgbotapi.Update) {
+	query := update.CallbackQuery
+
+	// the button stays in the loading state until the query is answered
+	if _, err := b.client.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		b.logger.Error("handleCallbackQuery: failed to answer callback query", "error", err)
+	}
+
+	if query.Message == nil {
+		return
+	}
+
+	requestId := logging.NewRequestId()
+	b.logger.Debug("RunUpdatesHandler: received callback query",
+		logging.RequestIdKey, requestId,
+		"update_id", update.UpdateID,
+		"chat_id", query.Message.Chat.ID,
+		"data", query.Data,
+		"user_name", query.From.UserName,
+	)
+
+	parts := strings.Split(query.Data, callbackSeparator)
+	command := b.callbackHandlerName(parts[0])
+
+	context := Context{
+		ChatId:           query.Message.Chat.ID,
+		UserId:           query.From.ID,
+		Command:          command,
+		CallbackArgs:     parts[1:],
+		RequestId:        requestId,
+		Update:           update,
+		responseMessages: make([]*tgbotapi.MessageConfig, 0),
+		aborted:          false,
+		bot:              b,
+	}
+
+	handlers, ok := b.handlers[command]
+	if !ok {
+		command = unknownCommand
+		handlers = []func(*Context){b.defaultHandler}
+	}
+
+	b.runHandlers(&context, command, handlers)
+}
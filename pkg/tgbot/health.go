@@ -0,0 +1,140 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxJobDuration is how long a scheduled job may run before the scheduler is considered wedged
+	maxJobDuration = 10 * time.Minute
+	// maxJobDelay is how long a scheduled job may be overdue before the scheduler is considered wedged
+	maxJobDelay = time.Minute
+	// maxUpdateDelay is how long received updates may wait without any update being handled
+	// before the updates handler is considered wedged
+	maxUpdateDelay = 5 * time.Minute
+)
+
+// jobTracker keeps start times of scheduled jobs which are running.
+type jobTracker struct {
+	mu      sync.Mutex
+	nextId  uint64
+	running map[uint64]runningJob
+}
+
+type runningJob struct {
+	name  string
+	start time.Time
+}
+
+func (t *jobTracker) begin(name string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running == nil {
+		t.running = make(map[uint64]runningJob)
+	}
+	t.nextId++
+	t.running[t.nextId] = runningJob{name: name, start: time.Now()}
+	return t.nextId
+}
+
+func (t *jobTracker) end(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.running, id)
+}
+
+// stuck returns a job which is running longer than max.
+func (t *jobTracker) stuck(max time.Duration) (runningJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, job := range t.running {
+		if time.Since(job.start) > max {
+			return job, true
+		}
+	}
+	return runningJob{}, false
+}
+
+// updateTracker counts received updates which are not handled yet and records when the handling progressed.
+type updateTracker struct {
+	pending atomic.Int64
+	// progress is the unix time in nanoseconds when an update was handled or the queue stopped being empty
+	progress atomic.Int64
+}
+
+func (t *updateTracker) received() {
+	if t.pending.Add(1) == 1 {
+		t.progress.Store(time.Now().UnixNano())
+	}
+}
+
+func (t *updateTracker) handled() {
+	t.progress.Store(time.Now().UnixNano())
+	t.pending.Add(-1)
+}
+
+// stalled returns the number of pending updates if none of them was handled for longer than max.
+func (t *updateTracker) stalled(max time.Duration) (int64, time.Time, bool) {
+	pending := t.pending.Load()
+	progress := time.Unix(0, t.progress.Load())
+	return pending, progress, pending > 0 && time.Since(progress) > max
+}
+
+// CheckScheduler returns an error if the scheduler is not running, a scheduled job
+// is running too long or jobs are not started on time.
+func (b *Bot) CheckScheduler() error {
+	if !b.scheduler.IsRunning() {
+		return fmt.Errorf("CheckScheduler: scheduler is not running")
+	}
+
+	if job, ok := b.jobs.stuck(maxJobDuration); ok {
+		return fmt.Errorf("CheckScheduler: %s is running since %s", job.name, job.start.Format(time.RFC3339))
+	}
+
+	for _, job := range b.scheduler.Jobs() {
+		if next := job.NextRun(); !next.IsZero() && time.Since(next) > maxJobDelay {
+			return fmt.Errorf("CheckScheduler: job is overdue since %s", next.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// CheckUpdatesHandler returns an error if updates are not handled anymore: the handler is stopped
+// or received updates are waiting while no update was handled for maxUpdateDelay.
+func (b *Bot) CheckUpdatesHandler() error {
+	select {
+	case <-b.done:
+		return fmt.Errorf("CheckUpdatesHandler: updates handler is stopped")
+	default:
+	}
+
+	if pending, progress, ok := b.updates.stalled(maxUpdateDelay); ok {
+		return fmt.Errorf("CheckUpdatesHandler: %d updates are waiting, no update is handled since %s", pending, progress.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CheckClient returns an error if the Telegram Bot API is not reachable with the bot token.
+func (b *Bot) CheckClient(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := b.client.GetMe()
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("CheckClient: failed to get bot: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("CheckClient: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,494 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/time/rate"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxMessageLength is the maximum length of a telegram message text
+	MaxMessageLength = 4096
+
+	// telegram allows about 30 messages per second overall, 1 message per second
+	// in a private chat and 20 messages per minute in a group
+	globalRate       = 30
+	privateChatRate  = rate.Limit(1)
+	groupChatRate    = rate.Limit(20.0 / 60)
+	chatBurst        = 3
+	maxSendAttempts  = 5
+	retryBaseBackoff = time.Second
+)
+
+// OutboundMessage is a message waiting in the outbound queue.
+type OutboundMessage struct {
+	Id                    uint64
+	ChatId                int64
+	Text                  string
+	ParseMode             string
+	DisableWebPagePreview bool
+	// ReplyMarkup is a JSON encoded reply markup
+	ReplyMarkup      string
+	ReplyToMessageId int
+	// Batch allows joining the message with adjacent batched messages of the chat
+	Batch     bool
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// OutboxStore persists messages which are not sent yet, so they survive restarts.
+type OutboxStore interface {
+	AddOutboundMessage(msg *OutboundMessage) error
+	UpdateOutboundMessage(msg *OutboundMessage) error
+	DeleteOutboundMessages(ids ...uint64) error
+	DeleteChatOutboundMessages(chatId int64) error
+	GetOutboundMessages() ([]*OutboundMessage, error)
+}
+
+type chatQueue struct {
+	messages     []*OutboundMessage
+	limiter      *rate.Limiter
+	blockedUntil time.Time
+}
+
+// outbox sends messages respecting telegram rate limits. Messages of a chat are sent
+// in order, chats are served round-robin.
+type outbox struct {
+	mu      sync.Mutex
+	client  *tgbotapi.BotAPI
+	store   OutboxStore
+	logger  *slog.Logger
+	global  *rate.Limiter
+	chats   map[int64]*chatQueue
+	order   []int64
+	next    int
+	pending int
+	notify  chan struct{}
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newOutbox(client *tgbotapi.BotAPI, logger *slog.Logger) *outbox {
+	return &outbox{
+		client: client,
+		store:  newMemoryOutboxStore(),
+		logger: logger,
+		global: rate.NewLimiter(globalRate, globalRate),
+		chats:  make(map[int64]*chatQueue),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// setStore replaces the store, run may already be reading it.
+func (o *outbox) setStore(store OutboxStore) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.store = store
+}
+
+func (o *outbox) getStore() OutboxStore {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.store
+}
+
+// load queues messages left in the store by the previous run.
+func (o *outbox) load() error {
+	messages, err := o.getStore().GetOutboundMessages()
+	if err != nil {
+		return fmt.Errorf("load: failed to get outbound messages: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, msg := range messages {
+		o.push(msg)
+	}
+	o.wake()
+
+	return nil
+}
+
+func (o *outbox) enqueue(config *tgbotapi.MessageConfig, batch bool) error {
+	msg, err := newOutboundMessage(config, batch)
+	if err != nil {
+		return err
+	}
+
+	if err := o.getStore().AddOutboundMessage(msg); err != nil {
+		return fmt.Errorf("enqueue: failed to store message: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		// the message is stored and will be sent after restart
+		return nil
+	}
+	o.push(msg)
+	o.wake()
+
+	return nil
+}
+
+func (o *outbox) push(msg *OutboundMessage) {
+	q, ok := o.chats[msg.ChatId]
+	if !ok {
+		limit := privateChatRate
+		if msg.ChatId < 0 {
+			limit = groupChatRate
+		}
+		q = &chatQueue{limiter: rate.NewLimiter(limit, chatBurst)}
+		o.chats[msg.ChatId] = q
+		o.order = append(o.order, msg.ChatId)
+	}
+	q.messages = append(q.messages, msg)
+	o.pending++
+}
+
+func (o *outbox) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the next batch of messages which can be sent now, or how long to wait for one.
+func (o *outbox) take() ([]*OutboundMessage, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	wait := time.Duration(-1)
+	now := time.Now()
+	for i := 0; i < len(o.order); i++ {
+		idx := (o.next + i) % len(o.order)
+		q := o.chats[o.order[idx]]
+		if len(q.messages) == 0 {
+			continue
+		}
+
+		if now.Before(q.blockedUntil) {
+			wait = minWait(wait, q.blockedUntil.Sub(now))
+			continue
+		}
+
+		r := q.limiter.ReserveN(now, 1)
+		if d := r.DelayFrom(now); d > 0 {
+			r.CancelAt(now)
+			wait = minWait(wait, d)
+			continue
+		}
+
+		o.next = (idx + 1) % len(o.order)
+		return batch(q.messages), 0
+	}
+
+	return nil, wait
+}
+
+// batch joins batched messages with the same options into one message up to MaxMessageLength.
+func batch(messages []*OutboundMessage) []*OutboundMessage {
+	first := messages[0]
+	if !first.Batch || first.ReplyMarkup != "" || first.ReplyToMessageId != 0 {
+		return messages[:1]
+	}
+
+	length := len(first.Text)
+	n := 1
+	for ; n < len(messages); n++ {
+		msg := messages[n]
+		if !msg.Batch ||
+			msg.ReplyMarkup != "" ||
+			msg.ReplyToMessageId != 0 ||
+			msg.ParseMode != first.ParseMode ||
+			msg.DisableWebPagePreview != first.DisableWebPagePreview ||
+			length+len("\n\n")+len(msg.Text) > MaxMessageLength {
+			break
+		}
+		length += len("\n\n") + len(msg.Text)
+	}
+
+	return messages[:n]
+}
+
+func minWait(a, b time.Duration) time.Duration {
+	if a < 0 || b < a {
+		return b
+	}
+	return a
+}
+
+// remove deletes sent or dropped messages from the queue and the store.
+func (o *outbox) remove(messages []*OutboundMessage) {
+	ids := make([]uint64, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.Id)
+	}
+
+	if err := o.getStore().DeleteOutboundMessages(ids...); err != nil {
+		o.logger.Error("outbox: failed to delete sent messages", "error", err)
+	}
+
+	removed := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q, ok := o.chats[messages[0].ChatId]
+	if !ok {
+		return
+	}
+	// the chat may be dropped while the messages are sent, so they are found by id
+	kept := make([]*OutboundMessage, 0, len(q.messages))
+	for _, msg := range q.messages {
+		if removed[msg.Id] {
+			o.pending--
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	q.messages = kept
+	if len(q.messages) == 0 {
+		o.compact()
+	}
+}
+
+// dropChat deletes messages of the chat which are not sent yet from the queue and the store.
+func (o *outbox) dropChat(chatId int64) error {
+	o.mu.Lock()
+	if q, ok := o.chats[chatId]; ok {
+		o.pending -= len(q.messages)
+		q.messages = nil
+	}
+	o.mu.Unlock()
+
+	if err := o.getStore().DeleteChatOutboundMessages(chatId); err != nil {
+		return fmt.Errorf("dropChat: failed to delete messages: %w", err)
+	}
+	return nil
+}
+
+// compact forgets chats without messages whose limiter is full again.
+func (o *outbox) compact() {
+	now := time.Now()
+	order := o.order[:0]
+	for _, id := range o.order {
+		q := o.chats[id]
+		if len(q.messages) == 0 && q.limiter.TokensAt(now) >= chatBurst && now.After(q.blockedUntil) {
+			delete(o.chats, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	o.order = order
+	if o.next >= len(o.order) {
+		o.next = 0
+	}
+}
+
+func (o *outbox) run() {
+	defer close(o.done)
+
+	for {
+		messages, wait := o.take()
+		if messages == nil {
+			var timer <-chan time.Time
+			if wait >= 0 {
+				timer = time.After(wait)
+			}
+			select {
+			case <-o.notify:
+			case <-timer:
+			case <-o.stop:
+				return
+			}
+			continue
+		}
+
+		if err := o.global.Wait(context.Background()); err != nil {
+			o.logger.Error("outbox: rate limiter failed", "error", err)
+		}
+		o.send(messages)
+
+		select {
+		case <-o.stop:
+			return
+		default:
+		}
+	}
+}
+
+func (o *outbox) send(messages []*OutboundMessage) {
+	config, err := messages[0].config(joinTexts(messages))
+	if err != nil {
+		o.logger.Error("outbox: dropping invalid message", "chat_id", messages[0].ChatId, "error", err)
+		o.remove(messages)
+		return
+	}
+
+	_, err = o.client.Send(config)
+	if err == nil {
+		o.remove(messages)
+		return
+	}
+
+	var apiErr *tgbotapi.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.RetryAfter > 0:
+		o.logger.Warn("outbox: rate limited by telegram", "chat_id", config.ChatID, "retry_after", apiErr.RetryAfter)
+		o.block(config.ChatID, time.Duration(apiErr.RetryAfter)*time.Second)
+	case errors.As(err, &apiErr) && apiErr.Code >= http.StatusBadRequest && apiErr.Code < http.StatusInternalServerError:
+		// the message is wrong or the bot is blocked by the user, retries don't help
+		o.logger.Error("outbox: failed to send message", "chat_id", config.ChatID, "error", err)
+		o.remove(messages)
+	default:
+		first := messages[0]
+		first.Attempts++
+		if first.Attempts >= maxSendAttempts {
+			o.logger.Error("outbox: failed to send message, giving up", "chat_id", config.ChatID, "attempts", first.Attempts, "error", err)
+			o.remove(messages)
+			return
+		}
+
+		o.logger.Warn("outbox: failed to send message, retrying", "chat_id", config.ChatID, "attempts", first.Attempts, "error", err)
+		if err := o.getStore().UpdateOutboundMessage(first); err != nil {
+			o.logger.Error("outbox: failed to update message", "error", err)
+		}
+		o.block(config.ChatID, retryBaseBackoff<<first.Attempts)
+	}
+}
+
+func (o *outbox) block(chatId int64, d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if q, ok := o.chats[chatId]; ok {
+		q.blockedUntil = time.Now().Add(d)
+	}
+}
+
+// close stops sending after all queued messages are sent or ctx is done.
+// Messages which are not sent stay in the store.
+func (o *outbox) close(ctx context.Context) error {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var err error
+loop:
+	for {
+		o.mu.Lock()
+		pending := o.pending
+		o.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = fmt.Errorf("close: %d messages are not sent: %w", pending, ctx.Err())
+			break loop
+		}
+	}
+
+	close(o.stop)
+	<-o.done
+	return err
+}
+
+func joinTexts(messages []*OutboundMessage) string {
+	texts := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		texts = append(texts, msg.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+func newOutboundMessage(config *tgbotapi.MessageConfig, batch bool) (*OutboundMessage, error) {
+	msg := &OutboundMessage{
+		ChatId:                config.ChatID,
+		Text:                  config.Text,
+		ParseMode:             config.ParseMode,
+		DisableWebPagePreview: config.DisableWebPagePreview,
+		ReplyToMessageId:      config.ReplyToMessageID,
+		Batch:                 batch,
+		CreatedAt:             time.Now(),
+	}
+
+	if config.ReplyMarkup != nil {
+		markup, err := json.Marshal(config.ReplyMarkup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal reply markup: %w", err)
+		}
+		msg.ReplyMarkup = string(markup)
+	}
+
+	return msg, nil
+}
+
+func (m *OutboundMessage) config(text string) (*tgbotapi.MessageConfig, error) {
+	config := CreateMessageWithOptions(m.ChatId, text, MessageWithOptions{
+		ParseMode:             m.ParseMode,
+		DisableWebPagePreview: m.DisableWebPagePreview,
+	})
+	config.ReplyToMessageID = m.ReplyToMessageId
+
+	if m.ReplyMarkup != "" {
+		if !json.Valid([]byte(m.ReplyMarkup)) {
+			return nil, fmt.Errorf("reply markup is not valid json")
+		}
+		config.ReplyMarkup = json.RawMessage(m.ReplyMarkup)
+	}
+
+	return config, nil
+}
+
+// memoryOutboxStore is used when no persistent store is set.
+type memoryOutboxStore struct {
+	mu     sync.Mutex
+	lastId uint64
+}
+
+func newMemoryOutboxStore() *memoryOutboxStore {
+	return &memoryOutboxStore{}
+}
+
+func (s *memoryOutboxStore) AddOutboundMessage(msg *OutboundMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastId++
+	msg.Id = s.lastId
+	return nil
+}
+
+func (s *memoryOutboxStore) UpdateOutboundMessage(*OutboundMessage) error {
+	return nil
+}
+
+func (s *memoryOutboxStore) DeleteOutboundMessages(...uint64) error {
+	return nil
+}
+
+func (s *memoryOutboxStore) DeleteChatOutboundMessages(int64) error {
+	return nil
+}
+
+func (s *memoryOutboxStore) GetOutboundMessages() ([]*OutboundMessage, error) {
+	return nil, nil
+}
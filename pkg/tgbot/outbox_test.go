@@ -0,0 +1,88 @@
+package tgbot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	batched := func(text string) *OutboundMessage {
+		return &OutboundMessage{ChatId: 1, Text: text, Batch: true}
+	}
+	single := func(text string) *OutboundMessage {
+		return &OutboundMessage{ChatId: 1, Text: text}
+	}
+	markdown := batched("*bold*")
+	markdown.ParseMode = tgbotapi.ModeMarkdownV2
+	withKeyboard := batched("pick one")
+	withKeyboard.ReplyMarkup = `{"inline_keyboard":[]}`
+	reply := batched("reply")
+	reply.ReplyToMessageId = 10
+	noPreview := batched("link")
+	noPreview.DisableWebPagePreview = true
+	long := batched(strings.Repeat("a", MaxMessageLength-10))
+
+	tests := []struct {
+		name     string
+		messages []*OutboundMessage
+		want     int
+	}{
+		{name: "single message", messages: []*OutboundMessage{single("a")}, want: 1},
+		{name: "messages which are not batched", messages: []*OutboundMessage{single("/watch a 1"), single("/watch b 2")}, want: 1},
+		{name: "batched messages", messages: []*OutboundMessage{batched("a"), batched("b"), batched("c")}, want: 3},
+		{name: "batched then not batched", messages: []*OutboundMessage{batched("a"), batched("b"), single("c")}, want: 2},
+		{name: "not batched then batched", messages: []*OutboundMessage{single("a"), batched("b")}, want: 1},
+		{name: "other parse mode", messages: []*OutboundMessage{batched("a"), markdown}, want: 1},
+		{name: "other preview option", messages: []*OutboundMessage{batched("a"), noPreview}, want: 1},
+		{name: "keyboard first", messages: []*OutboundMessage{withKeyboard, batched("a")}, want: 1},
+		{name: "keyboard next", messages: []*OutboundMessage{batched("a"), withKeyboard}, want: 1},
+		{name: "reply first", messages: []*OutboundMessage{reply, batched("a")}, want: 1},
+		{name: "reply next", messages: []*OutboundMessage{batched("a"), reply}, want: 1},
+		{name: "too long", messages: []*OutboundMessage{batched("0123456789"), long}, want: 1},
+		{name: "fits exactly", messages: []*OutboundMessage{batched("01234567"), long}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := batch(tt.messages)
+			if len(got) != tt.want {
+				t.Fatalf("batch() returned %d messages, want %d", len(got), tt.want)
+			}
+			if text := joinTexts(got); len(text) > MaxMessageLength {
+				t.Errorf("joined text is %d long, max is %d", len(text), MaxMessageLength)
+			}
+		})
+	}
+}
+
+func TestDropChat(t *testing.T) {
+	o := newOutbox(nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, chatId := range []int64{1, 1, 2} {
+		if err := o.enqueue(&tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: chatId}, Text: "text"}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first message of chat 1 is being sent while the chat is dropped
+	sending, _ := o.take()
+	if len(sending) != 1 || sending[0].ChatId != 1 {
+		t.Fatalf("take() = %v, want a message of chat 1", sending)
+	}
+	if err := o.dropChat(1); err != nil {
+		t.Fatalf("dropChat() error = %v", err)
+	}
+	if err := o.enqueue(&tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: 1}, Text: "bye"}, false); err != nil {
+		t.Fatal(err)
+	}
+	o.remove(sending)
+
+	if o.pending != 2 {
+		t.Errorf("pending = %d, want 2", o.pending)
+	}
+	if q := o.chats[1]; len(q.messages) != 1 || q.messages[0].Text != "bye" {
+		t.Errorf("messages of chat 1 = %v, want the one enqueued after dropping", q.messages)
+	}
+}
@@ -1,27 +1,73 @@
 package tgbot
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-co-op/gocron"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/config"
+	"github.com/ibovyrin/go-plan-it/pkg/logging"
+	"github.com/ibovyrin/go-plan-it/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"os"
-	"slices"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	defaultWorkers = 16
+	// unknownCommand labels updates without a registered handler
+	unknownCommand = "unknown"
+)
+
+var tracer = otel.Tracer("github.com/ibovyrin/go-plan-it/pkg/tgbot")
+
 type MessageWithOptions struct {
 	ParseMode             string
 	DisableWebPagePreview bool
+	InlineKeyboard        *tgbotapi.InlineKeyboardMarkup
 }
 
 type Context struct {
-	ChatId           int64
-	Command          string
+	ChatId int64
+	// UserId is the sender of the update, it differs from ChatId in groups
+	UserId  int64
+	Command string
+	// CallbackArgs are arguments of the callback data when the update is a callback query
+	CallbackArgs []string
+	// RequestId correlates log records of the update or the scheduled job
+	RequestId        string
 	Update           tgbotapi.Update
 	responseMessages []*tgbotapi.MessageConfig
 	aborted          bool
 	bot              *Bot
+	// ctx carries the span of the running handler
+	ctx context.Context
+	// batched are response messages which may be joined with adjacent batched messages of the chat
+	batched map[*tgbotapi.MessageConfig]bool
+}
+
+// Context returns the context of the running handler, calls made with it are traced as its children.
+func (c *Context) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Logger returns logger with the correlation id and the trace id of the context.
+func (c *Context) Logger(logger *slog.Logger) *slog.Logger {
+	logger = logger.With(logging.RequestIdKey, c.RequestId)
+	if span := trace.SpanContextFromContext(c.Context()); span.HasTraceID() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	return logger
 }
 
 func (c *Context) Abort() {
@@ -37,10 +83,29 @@ func (c *Context) IsAborted() bool {
 	return c.aborted
 }
 
+// IsGroup reports whether the update comes from a group chat.
+func (c *Context) IsGroup() bool {
+	chat := c.Update.FromChat()
+	return chat != nil && (chat.IsGroup() || chat.IsSuperGroup())
+}
+
+// RegisterWaitForInput makes the next message of the user in the chat go to the response handler of the command.
+// In groups the last response message asks the user to reply to it, so the bot receives the input in privacy mode.
 func (c *Context) RegisterWaitForInput() {
 	command := c.bot.responseHandlerName(c.Command)
-	c.bot.waitFor[c.ChatId] = WaitForCommand{
-		UpdateID: c.Update.UpdateID + 1,
+
+	if c.IsGroup() && c.Update.Message != nil && len(c.responseMessages) > 0 {
+		msg := c.responseMessages[len(c.responseMessages)-1]
+		msg.ReplyToMessageID = c.Update.Message.MessageID
+		if msg.ReplyMarkup == nil {
+			msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+		}
+	}
+
+	c.bot.waitForMu.Lock()
+	defer c.bot.waitForMu.Unlock()
+	c.bot.waitFor[waitKey{chatId: c.ChatId, userId: c.UserId}] = WaitForCommand{
+		UpdateID: c.Update.UpdateID,
 		Command:  command,
 	}
 }
@@ -49,6 +114,16 @@ func (c *Context) AddMessageConfig(msg *tgbotapi.MessageConfig) {
 	c.responseMessages = append(c.responseMessages, msg)
 }
 
+// AddBatchMessageConfig adds a message which may be joined with adjacent batched messages of the chat,
+// like parts of a digest. Other messages are always sent separately.
+func (c *Context) AddBatchMessageConfig(msg *tgbotapi.MessageConfig) {
+	if c.batched == nil {
+		c.batched = make(map[*tgbotapi.MessageConfig]bool)
+	}
+	c.batched[msg] = true
+	c.responseMessages = append(c.responseMessages, msg)
+}
+
 func (c *Context) AddMessage(message string) {
 	msg := CreateMessage(c.ChatId, message)
 	c.responseMessages = append(c.responseMessages, msg)
@@ -63,49 +138,66 @@ func (c *Context) GetMessages() []*tgbotapi.MessageConfig {
 	return c.responseMessages
 }
 
+type waitKey struct {
+	chatId int64
+	userId int64
+}
+
 type WaitForCommand struct {
+	// UpdateID is the update which registered the command, only later updates are handled by it
 	UpdateID int
 	Command  string
 }
 
 type Bot struct {
 	client         *tgbotapi.BotAPI
-	waitFor        map[int64]WaitForCommand
+	waitFor        map[waitKey]WaitForCommand
+	waitForMu      sync.Mutex
 	handlers       map[string][]func(*Context)
 	updatesTimeout int
+	workers        int
 	scheduler      *gocron.Scheduler
 	logger         *slog.Logger
-	allowList      []string
+	middlewares    []func(*Context)
+	webhook        *webhook
+	outbox         *outbox
+	jobs           jobTracker
+	updates        updateTracker
+	stop           chan struct{}
+	done           chan struct{}
 }
 
-func NewBot(updatesTimeout int, scheduler *gocron.Scheduler, logger ...*slog.Logger) (*Bot, error) {
-	var tgBotToken = os.Getenv("TG_BOT_TOKEN")
-
-	if tgBotToken == "" {
-		return nil, fmt.Errorf("TG_BOT_TOKEN env variable is not set")
+func NewBot(config config.Telegram, scheduler *gocron.Scheduler, logger ...*slog.Logger) (*Bot, error) {
+	if config.Token == "" {
+		return nil, fmt.Errorf("telegram token is not set")
 
 	}
 
-	var allowList = os.Getenv("TG_BOT_ALLOW_LIST")
-
-	client, err := tgbotapi.NewBotAPI(tgBotToken)
+	client, err := tgbotapi.NewBotAPI(config.Token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new bot: %w", err)
 
 	}
 
-	client.Debug = true
 	bot := &Bot{
 		client:         client,
-		waitFor:        make(map[int64]WaitForCommand),
-		updatesTimeout: updatesTimeout,
+		waitFor:        make(map[waitKey]WaitForCommand),
+		updatesTimeout: config.UpdatesTimeout,
+		workers:        config.Workers,
 		scheduler:      scheduler,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
 	}
 
-	if allowList == "" {
-		bot.allowList = make([]string, 0)
-	} else {
-		bot.allowList = strings.Split(allowList, ",")
+	if bot.workers < 1 {
+		bot.workers = defaultWorkers
+	}
+
+	if config.WebhookURL != "" {
+		bot.webhook, err = newWebhook(config.WebhookURL, config.WebhookSecret, config.Token)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if len(logger) > 0 {
@@ -113,14 +205,44 @@ func NewBot(updatesTimeout int, scheduler *gocron.Scheduler, logger ...*slog.Log
 	} else {
 		bot.logger = slog.New(slog.NewTextHandler(os.Stdout, nil)).WithGroup("tgbot")
 	}
+	// the library logs errors of getting updates with request URLs which contain the token
+	if err := tgbotapi.SetLogger(apiLogger{logger: bot.logger}); err != nil {
+		return nil, fmt.Errorf("failed to set bot api logger: %w", err)
+	}
+
+	bot.outbox = newOutbox(client, bot.logger)
+	go bot.outbox.run()
 
 	return bot, nil
 }
 
+// apiLogger writes logs of the bot api library with slog.
+type apiLogger struct {
+	logger *slog.Logger
+}
+
+func (l apiLogger) Println(v ...interface{}) {
+	l.logger.Warn(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func (l apiLogger) Printf(format string, v ...interface{}) {
+	l.logger.Warn(fmt.Sprintf(format, v...))
+}
+
 func (b *Bot) responseHandlerName(command string) string {
 	return fmt.Sprintf("%s_responseHandler", command)
 }
 
+// Use adds middlewares which run before the handlers of every command and callback.
+func (b *Bot) Use(middlewares ...func(*Context)) {
+	b.middlewares = append(b.middlewares, middlewares...)
+}
+
+// Username returns the telegram username of the bot.
+func (b *Bot) Username() string {
+	return b.client.Self.UserName
+}
+
 func (b *Bot) RegisterCommand(command string, handlers []func(*Context), responseHandler ...bool) {
 	if b.handlers == nil {
 		b.handlers = make(map[string][]func(*Context))
@@ -134,17 +256,41 @@ func (b *Bot) RegisterCommand(command string, handlers []func(*Context), respons
 	b.logger.Debug("RegisterCommand: added handler", "command", command)
 }
 
-func (b *Bot) scheduledHandlerWrapper(handler func(*Context)) {
-	context := Context{
+func (b *Bot) scheduledHandlerWrapper(name string, handler func(*Context)) {
+	id := b.jobs.begin(name)
+	defer b.jobs.end(id)
+
+	start := time.Now()
+	defer func() {
+		metrics.ScheduledJobDuration.WithLabelValues(name).Observe(metrics.Since(start))
+	}()
+
+	requestId := logging.NewRequestId()
+	ctx, span := tracer.Start(context.Background(), "scheduled "+name, trace.WithAttributes(
+		attribute.String(logging.RequestIdKey, requestId),
+	))
+	defer span.End()
+
+	c := Context{
+		RequestId:        requestId,
 		responseMessages: make([]*tgbotapi.MessageConfig, 0),
 		aborted:          false,
+		ctx:              ctx,
 	}
-	handler(&context)
-	b.SendMessages(context.GetMessages())
+	c.Logger(b.logger).Debug("scheduledHandlerWrapper: running job", "job", name)
+	handler(&c)
+	b.sendResponse(&c)
+}
+
+// handlerName returns the name of a function like SendNotifications for a method value.
+func handlerName(handler func(*Context)) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
 }
 
 func (b *Bot) RegisterScheduledHandler(cron string, handler func(*Context)) error {
-	if _, err := b.scheduler.CronWithSeconds(cron).Do(b.scheduledHandlerWrapper, handler); err != nil {
+	if _, err := b.scheduler.CronWithSeconds(cron).Do(b.scheduledHandlerWrapper, handlerName(handler), handler); err != nil {
 		return err
 	}
 	return nil
@@ -154,88 +300,275 @@ func (b *Bot) defaultHandler(context *Context) {
 	context.AbortWithMessage("I don't know what to do with this message.")
 }
 
-func (b *Bot) getHandlers(chatId int64, updateId int, command string) []func(*Context) {
-	wait, ok := b.waitFor[chatId]
+// getHandlers returns handlers of the command or of the input the user is asked for. In groups
+// nil is returned for messages which are not meant for the bot.
+func (b *Bot) getHandlers(key waitKey, updateId int, command string, group bool) (string, []func(*Context)) {
+	b.waitForMu.Lock()
+	wait, ok := b.waitFor[key]
 	if ok {
-		delete(b.waitFor, chatId)
+		delete(b.waitFor, key)
 	}
+	b.waitForMu.Unlock()
 	b.logger.Debug(fmt.Sprintf("wait %v", wait))
 
-	if wait.UpdateID == updateId && command == "" {
+	if ok && updateId > wait.UpdateID && command == "" {
 		command = wait.Command
 	}
 
 	handlers, ok := b.handlers[command]
 	if !ok {
+		if group {
+			return command, nil
+		}
+		command = unknownCommand
 		handlers = []func(*Context){b.defaultHandler}
 	}
 
 	b.logger.Debug("getHandlers: discovered handlers", "handlers_num", len(handlers), "command", command)
-	return handlers
+	return command, handlers
+}
+
+// SetOutboxStore sets a persistent store for the outbound queue and queues messages left in it by the
+// previous run. It must be called before any message is sent, otherwise the message is sent twice.
+func (b *Bot) SetOutboxStore(store OutboxStore) error {
+	b.outbox.setStore(store)
+	if err := b.outbox.load(); err != nil {
+		return fmt.Errorf("SetOutboxStore: failed to load outbound messages: %w", err)
+	}
+	return nil
 }
 
+// SendMessages puts messages to the outbound queue, they are sent respecting telegram rate limits.
 func (b *Bot) SendMessages(messages []*tgbotapi.MessageConfig) {
 	for _, message := range messages {
-		if _, err := b.client.Send(message); err != nil {
-			b.logger.Error("SendMessages: failed to send message", "error", err)
-		}
+		b.enqueue(message, false)
 	}
 }
 
-func (b *Bot) RunUpdatesHandler() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = b.updatesTimeout
-	updates := b.client.GetUpdatesChan(u)
+// SendBatchMessages puts messages to the outbound queue like SendMessages, adjacent messages
+// of a chat may be joined into one.
+func (b *Bot) SendBatchMessages(messages []*tgbotapi.MessageConfig) {
+	for _, message := range messages {
+		b.enqueue(message, true)
+	}
+}
 
-	b.scheduler.StartAsync()
+// DropChatMessages deletes messages of the chat which are not sent yet, responses
+// of the running handler are sent after it returns.
+func (b *Bot) DropChatMessages(chatId int64) error {
+	if err := b.outbox.dropChat(chatId); err != nil {
+		return fmt.Errorf("DropChatMessages: %w", err)
+	}
+	return nil
+}
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
+// sendResponse puts response messages of the handlers to the outbound queue.
+func (b *Bot) sendResponse(c *Context) {
+	for _, message := range c.responseMessages {
+		b.enqueue(message, c.batched[message])
+	}
+}
 
-		b.logger.Debug("RunUpdatesHandler: received update",
-			"update_id",
-			update.UpdateID,
-			"chat_id",
-			update.Message.Chat.ID,
-			"text",
-			update.Message.Text,
-			"command", update.Message.Command(),
-			"user_name", update.Message.From.UserName,
-		)
-
-		if len(b.allowList) > 0 {
-			if !slices.Contains(b.allowList, update.Message.From.UserName) {
-				msg := CreateMessage(update.Message.Chat.ID, "Sorry, I can't talk to you.")
-				b.SendMessages([]*tgbotapi.MessageConfig{msg})
-				continue
-			}
-		}
+func (b *Bot) enqueue(message *tgbotapi.MessageConfig, batch bool) {
+	if err := b.outbox.enqueue(message, batch); err != nil {
+		b.logger.Error("enqueue: failed to queue message", "error", err)
+	}
+}
 
-		context := Context{
-			ChatId:           update.Message.Chat.ID,
-			Command:          update.Message.Command(),
-			Update:           update,
-			responseMessages: make([]*tgbotapi.MessageConfig, 0),
-			aborted:          false,
-			bot:              b,
-		}
+// RunUpdatesHandler receives updates and runs scheduled handlers until StopUpdatesHandler is called.
+func (b *Bot) RunUpdatesHandler() error {
+	defer close(b.done)
+
+	updates, err := b.updatesChan()
+	if err != nil {
+		return fmt.Errorf("RunUpdatesHandler: failed to receive updates: %w", err)
+	}
 
-		for _, handler := range b.getHandlers(update.Message.Chat.ID, update.UpdateID, update.Message.Command()) {
-			handler(&context)
-			if context.IsAborted() {
-				break
+	b.scheduler.StartAsync()
+
+	pool := newWorkerPool(b.workers, func(update tgbotapi.Update) {
+		b.handleUpdate(update)
+		b.updates.handled()
+	})
+	// updates which are already received are handled before returning
+	defer pool.stop()
+
+	for {
+		select {
+		case <-b.stop:
+			for {
+				select {
+				case update, ok := <-updates:
+					if !ok {
+						return nil
+					}
+					b.updates.received()
+					pool.dispatch(update)
+				default:
+					return nil
+				}
+			}
+		case update, ok := <-updates:
+			if !ok {
+				return nil
 			}
+			b.updates.received()
+			pool.dispatch(update)
 		}
+	}
+}
+
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	switch {
+	case update.Message != nil:
+		b.handleMessage(update)
+	case update.CallbackQuery != nil:
+		b.handleCallbackQuery(update)
+	}
+}
+
+func (b *Bot) handleMessage(update tgbotapi.Update) {
+	requestId := logging.NewRequestId()
+	b.logger.Debug("RunUpdatesHandler: received update",
+		logging.RequestIdKey, requestId,
+		"update_id", update.UpdateID,
+		"chat_id", update.Message.Chat.ID,
+		"text", update.Message.Text,
+		"command", update.Message.Command(),
+		"user_name", update.Message.From.UserName,
+	)
+
+	if !b.isAddressedToBot(update.Message) {
+		return
+	}
 
-		b.SendMessages(context.GetMessages())
+	context := Context{
+		ChatId:           update.Message.Chat.ID,
+		UserId:           update.Message.From.ID,
+		Command:          update.Message.Command(),
+		RequestId:        requestId,
+		Update:           update,
+		responseMessages: make([]*tgbotapi.MessageConfig, 0),
+		aborted:          false,
+		bot:              b,
 	}
+
+	key := waitKey{chatId: context.ChatId, userId: context.UserId}
+	name, handlers := b.getHandlers(key, update.UpdateID, context.Command, context.IsGroup())
+	if handlers == nil {
+		return
+	}
+
+	b.runHandlers(&context, name, handlers)
 }
 
+// isAddressedToBot reports whether a command without a suffix or with the @botname suffix of this bot is sent.
+// Commands of other bots in groups look like /command@otherbot.
+func (b *Bot) isAddressedToBot(message *tgbotapi.Message) bool {
+	if !message.IsCommand() {
+		return true
+	}
+
+	_, name, found := strings.Cut(message.CommandWithAt(), "@")
+	return !found || strings.EqualFold(name, b.client.Self.UserName)
+}
+
+// IsChatAdmin reports whether the user is an administrator or the creator of the chat.
+func (b *Bot) IsChatAdmin(chatId, userId int64) (bool, error) {
+	member, err := b.client.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatId, UserID: userId},
+	})
+	if err != nil {
+		return false, fmt.Errorf("IsChatAdmin: failed to get chat member: %w", err)
+	}
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
+
+// runHandlers runs middlewares and handlers, name is the registered command or callback they belong to.
+func (b *Bot) runHandlers(context *Context, name string, handlers []func(*Context)) {
+	updateType := "message"
+	if context.Update.CallbackQuery != nil {
+		updateType = "callback_query"
+	}
+	metrics.TelegramUpdates.WithLabelValues(updateType, name).Inc()
+
+	start := time.Now()
+	defer func() {
+		metrics.HandlerDuration.WithLabelValues(name).Observe(metrics.Since(start))
+	}()
+
+	ctx, span := tracer.Start(context.Context(), "update "+name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("telegram.update_type", updateType),
+		attribute.Int("telegram.update_id", context.Update.UpdateID),
+		attribute.Int64("telegram.chat_id", context.ChatId),
+		attribute.String(logging.RequestIdKey, context.RequestId),
+	))
+	defer span.End()
+
+	chain := make([]func(*Context), 0, len(b.middlewares)+len(handlers))
+	chain = append(chain, b.middlewares...)
+	chain = append(chain, handlers...)
+
+	for _, handler := range chain {
+		var handlerSpan trace.Span
+		context.ctx, handlerSpan = tracer.Start(ctx, handlerName(handler))
+		handler(context)
+		handlerSpan.End()
+		context.ctx = ctx
+
+		if context.IsAborted() {
+			span.SetAttributes(attribute.String("telegram.aborted_by", handlerName(handler)))
+			break
+		}
+	}
+
+	b.sendResponse(context)
+}
+
+// StopUpdatesHandler stops receiving updates, it doesn't wait for in-flight handlers.
 func (b *Bot) StopUpdatesHandler() {
-	b.scheduler.Stop()
-	b.client.StopReceivingUpdates()
+	if b.webhook != nil {
+		// updates which are being enqueued are still handled, later webhook requests see the bot is stopped
+		b.webhook.mu.Lock()
+		defer b.webhook.mu.Unlock()
+	}
+
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+		b.client.StopReceivingUpdates()
+	}
+}
+
+// Shutdown stops receiving updates, waits for in-flight update handlers and
+// scheduled jobs to finish and flushes the outbound queue, or until ctx is done.
+func (b *Bot) Shutdown(ctx context.Context) error {
+	b.StopUpdatesHandler()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return fmt.Errorf("Shutdown: update handlers are not finished: %w", ctx.Err())
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		b.scheduler.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("Shutdown: scheduled jobs are not finished: %w", ctx.Err())
+	}
+
+	if err := b.outbox.close(ctx); err != nil {
+		return fmt.Errorf("Shutdown: %w", err)
+	}
+
+	return nil
 }
 
 func CreateMessageWithOptions(chatId int64, text string, options ...MessageWithOptions) *tgbotapi.MessageConfig {
@@ -243,6 +576,9 @@ func CreateMessageWithOptions(chatId int64, text string, options ...MessageWithO
 	if len(options) > 0 {
 		msg.DisableWebPagePreview = options[0].DisableWebPagePreview
 		msg.ParseMode = options[0].ParseMode
+		if options[0].InlineKeyboard != nil {
+			msg.ReplyMarkup = options[0].InlineKeyboard
+		}
 	}
 	return &msg
 }
@@ -0,0 +1,142 @@
+package tgbot
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ibovyrin/go-plan-it/pkg/metrics"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+type webhook struct {
+	url     string
+	path    string
+	secret  string
+	updates chan tgbotapi.Update
+	// mu is read locked while an update is enqueued, so none is enqueued after the updates handler stops
+	mu sync.RWMutex
+}
+
+func newWebhook(baseURL, secret, botToken string) (*webhook, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("telegram webhook secret is not set")
+	}
+
+	// the path is derived from the bot token, so it can't be guessed and doesn't change between restarts
+	hash := sha256.Sum256([]byte(botToken))
+	path := "/telegram/" + hex.EncodeToString(hash[:16])
+
+	u, err := url.JoinPath(baseURL, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook url: %w", err)
+	}
+
+	return &webhook{
+		url:     u,
+		path:    path,
+		secret:  secret,
+		updates: make(chan tgbotapi.Update, 100),
+	}, nil
+}
+
+// WebhookEnabled reports whether the bot receives updates through a webhook instead of long polling.
+func (b *Bot) WebhookEnabled() bool {
+	return b.webhook != nil
+}
+
+// WebhookPath is the path HandleWebhook must be registered on.
+func (b *Bot) WebhookPath() string {
+	if b.webhook == nil {
+		return ""
+	}
+	return b.webhook.path
+}
+
+// HandleWebhook receives updates from telegram and passes them to the updates handler.
+func (b *Bot) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if b.webhook == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	secret := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(b.webhook.secret)) != 1 {
+		b.logger.Warn("HandleWebhook: wrong secret token", "remote_addr", r.RemoteAddr)
+		metrics.WebhookDeliveries.WithLabelValues("telegram", "unauthorized").Inc()
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	update, err := b.client.HandleUpdate(r)
+	if err != nil {
+		b.logger.Error("HandleWebhook: failed to parse update", "error", err)
+		metrics.WebhookDeliveries.WithLabelValues("telegram", "bad_request").Inc()
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b.webhook.mu.RLock()
+	defer b.webhook.mu.RUnlock()
+
+	select {
+	case <-b.stop:
+		// nothing reads the updates anymore, telegram delivers the update again when the bot is back
+		metrics.WebhookDeliveries.WithLabelValues("telegram", "unavailable").Inc()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	select {
+	case b.webhook.updates <- *update:
+		metrics.WebhookDeliveries.WithLabelValues("telegram", "ok").Inc()
+		w.WriteHeader(http.StatusOK)
+	case <-r.Context().Done():
+		metrics.WebhookDeliveries.WithLabelValues("telegram", "unavailable").Inc()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func (b *Bot) setWebhook() error {
+	params := tgbotapi.Params{
+		"url":          b.webhook.url,
+		"secret_token": b.webhook.secret,
+	}
+
+	if _, err := b.client.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("setWebhook: %w", err)
+	}
+	return nil
+}
+
+func (b *Bot) deleteWebhook() error {
+	if _, err := b.client.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("deleteWebhook: %w", err)
+	}
+	return nil
+}
+
+// updatesChan returns updates received through the webhook or long polling.
+func (b *Bot) updatesChan() (tgbotapi.UpdatesChannel, error) {
+	if b.webhook != nil {
+		if err := b.setWebhook(); err != nil {
+			return nil, err
+		}
+		return b.webhook.updates, nil
+	}
+
+	// getUpdates doesn't work while a webhook is set
+	if err := b.deleteWebhook(); err != nil {
+		return nil, err
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = b.updatesTimeout
+	return b.client.GetUpdatesChan(u), nil
+}
@@ -0,0 +1,52 @@
+package tgbot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		secret      string
+		stopped     bool
+		wantStatus  int
+		wantUpdates int
+	}{
+		{name: "update is enqueued", secret: "secret", wantStatus: http.StatusOK, wantUpdates: 1},
+		{name: "wrong secret", secret: "other", wantStatus: http.StatusUnauthorized},
+		{name: "stopped bot", secret: "secret", stopped: true, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bot{
+				client:  &tgbotapi.BotAPI{},
+				logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+				webhook: &webhook{secret: "secret", updates: make(chan tgbotapi.Update, 1)},
+				stop:    make(chan struct{}),
+			}
+			if tt.stopped {
+				close(b.stop)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/telegram/path", strings.NewReader(`{"update_id": 1}`))
+			r.Header.Set(secretTokenHeader, tt.secret)
+			w := httptest.NewRecorder()
+
+			b.HandleWebhook(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if len(b.webhook.updates) != tt.wantUpdates {
+				t.Errorf("enqueued %d updates, want %d", len(b.webhook.updates), tt.wantUpdates)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package tgbot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+)
+
+const workerQueueSize = 100
+
+// workerPool handles updates of different chats in parallel. Updates of the
+// same chat always go to the same worker, so they are handled in order.
+type workerPool struct {
+	queues []chan tgbotapi.Update
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(size int, handle func(tgbotapi.Update)) *workerPool {
+	p := &workerPool{queues: make([]chan tgbotapi.Update, size)}
+
+	for i := range p.queues {
+		queue := make(chan tgbotapi.Update, workerQueueSize)
+		p.queues[i] = queue
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for update := range queue {
+				handle(update)
+			}
+		}()
+	}
+
+	return p
+}
+
+func (p *workerPool) dispatch(update tgbotapi.Update) {
+	p.queues[uint64(updateChatId(update))%uint64(len(p.queues))] <- update
+}
+
+// stop waits until all dispatched updates are handled.
+func (p *workerPool) stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+func updateChatId(update tgbotapi.Update) int64 {
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	return 0
+}